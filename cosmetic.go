@@ -0,0 +1,310 @@
+package adblockgoparser
+
+import (
+	"sort"
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// Separators between the domains and the selector of element hiding rules,
+// longest first
+var cosmeticSeparators = []struct {
+	text        string
+	isException bool
+	isExtended  bool
+}{
+	{"#@?#", true, true},
+	{"#?#", false, true},
+	{"#@#", true, false},
+	{"##", false, false},
+}
+
+// CosmeticRule element hiding rule, hiding the elements matching a CSS
+// selector on some pages
+type CosmeticRule struct {
+	rawText     string
+	source      string
+	selector    string
+	domains     map[string]bool
+	isException bool
+	isExtended  bool
+	procedural  *ProceduralSelector
+}
+
+// ParseCosmeticRule parse and create a CosmeticRule from an element hiding
+// rule, like example.com##.ad-banner or example.com#@#.ad-banner
+func ParseCosmeticRule(ruleText string) (*CosmeticRule, error) {
+	ruleText = strings.TrimSpace(ruleText)
+	if ruleText == "" {
+		return nil, ErrEmptyLine
+	}
+
+	rule := &CosmeticRule{rawText: ruleText}
+
+	// The first separator in the text splits the rule
+	start, end := -1, -1
+	for _, separator := range cosmeticSeparators {
+		if index := strings.Index(ruleText, separator.text); index >= 0 && (start < 0 || index < start) {
+			start, end = index, index+len(separator.text)
+			rule.isException = separator.isException
+			rule.isExtended = separator.isExtended
+		}
+	}
+	if start < 0 {
+		return nil, &ParseError{Rule: ruleText, Err: ErrUnsupportedRule}
+	}
+	rule.selector = strings.TrimSpace(ruleText[end:])
+
+	// Scriptlets and HTML filtering use their own syntax after the separator
+	if rule.selector == "" || strings.HasPrefix(rule.selector, "+js(") || strings.HasPrefix(rule.selector, "^") {
+		return nil, &ParseError{Rule: ruleText, Err: ErrUnsupportedRule}
+	}
+
+	if rule.isExtended || isProcedural(rule.selector) {
+		procedural, err := ParseProceduralSelector(rule.selector)
+		if err != nil {
+			return nil, &ParseError{Rule: ruleText, Err: err}
+		}
+		// Extended rules may be plain CSS
+		if len(procedural.Steps) > 1 || procedural.Steps[0].Operator != OperatorCSS || procedural.Remove {
+			rule.procedural = procedural
+		}
+	}
+
+	rule.domains = parseDomains(ruleText[:start])
+	return rule, nil
+}
+
+// Selector returns the CSS selector of the elements to hide
+func (rule *CosmeticRule) Selector() string {
+	return rule.selector
+}
+
+// Procedural returns the parsed extended selector, nil for plain CSS rules
+func (rule *CosmeticRule) Procedural() *ProceduralSelector {
+	return rule.procedural
+}
+
+// IsException tells if the rule stops hiding the elements on its domains
+func (rule *CosmeticRule) IsException() bool {
+	return rule.isException
+}
+
+// IsGeneric tells if the rule is not restricted to some domains
+func (rule *CosmeticRule) IsGeneric() bool {
+	for _, active := range rule.domains {
+		if active {
+			return false
+		}
+	}
+	return true
+}
+
+// IncludedDomains returns the sorted domains the rule is restricted to
+func (rule *CosmeticRule) IncludedDomains() []string {
+	return domainList(rule.domains, true)
+}
+
+// ExcludedDomains returns the sorted domains the rule doesn't apply to
+func (rule *CosmeticRule) ExcludedDomains() []string {
+	return domainList(rule.domains, false)
+}
+
+// Text returns the rule as it was written in the list
+func (rule *CosmeticRule) Text() string {
+	return rule.rawText
+}
+
+// Source returns the name of the list the rule was loaded from, if any
+func (rule *CosmeticRule) Source() string {
+	return rule.source
+}
+
+// String returns the rule in canonical filter syntax, domains sorted by name
+func (rule *CosmeticRule) String() string {
+	separator := "##"
+	for _, cosmeticSeparator := range cosmeticSeparators {
+		if cosmeticSeparator.isException == rule.isException && cosmeticSeparator.isExtended == rule.isExtended {
+			separator = cosmeticSeparator.text
+		}
+	}
+	return domainsText(rule.domains) + separator + rule.selector
+}
+
+func (rule *CosmeticRule) appliesTo(hostname string) bool {
+	return len(rule.domains) == 0 || matchDocumentDomains(rule.domains, hostname)
+}
+
+// parseDomains parses the comma separated domains before the separator of a
+// cosmetic rule, negated with ~
+func parseDomains(text string) map[string]bool {
+	domains := map[string]bool{}
+	if text != "" {
+		for _, domain := range strings.Split(text, ",") {
+			name := strings.TrimSpace(domain)
+			domains[normalizeHostname(strings.TrimPrefix(name, "~"))] = !strings.HasPrefix(name, "~")
+		}
+	}
+	return domains
+}
+
+// domainsText returns the domains of a cosmetic rule sorted by name
+func domainsText(domains map[string]bool) string {
+	names := make([]string, 0, len(domains))
+	for domain, active := range domains {
+		if !active {
+			domain = "~" + domain
+		}
+		names = append(names, domain)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return strings.TrimPrefix(names[i], "~") < strings.TrimPrefix(names[j], "~")
+	})
+	return strings.Join(names, ",")
+}
+
+// domainList returns the sorted included or excluded domains of the list
+func domainList(domains map[string]bool, included bool) []string {
+	var names []string
+	for domain, active := range domains {
+		if active == included {
+			names = append(names, domain)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// CosmeticSet handle the element hiding and scriptlet rules to find the
+// selectors to hide and the scriptlets to run on a page
+type CosmeticSet struct {
+	generic    []*CosmeticRule
+	specific   map[string][]*CosmeticRule
+	exceptions map[string][]*CosmeticRule
+
+	genericScriptlets   []*ScriptletRule
+	specificScriptlets  map[string][]*ScriptletRule
+	scriptletExceptions []*ScriptletRule
+}
+
+// CreateCosmeticSet Creates a fresh new empty CosmeticSet
+func CreateCosmeticSet() *CosmeticSet {
+	return &CosmeticSet{
+		specific:           map[string][]*CosmeticRule{},
+		exceptions:         map[string][]*CosmeticRule{},
+		specificScriptlets: map[string][]*ScriptletRule{},
+	}
+}
+
+// AddRule Adds rule to the set
+func (set *CosmeticSet) AddRule(rule *CosmeticRule) {
+	switch {
+	case rule.isException:
+		set.exceptions[rule.selector] = append(set.exceptions[rule.selector], rule)
+	case rule.IsGeneric():
+		set.generic = append(set.generic, rule)
+	default:
+		for _, domain := range rule.IncludedDomains() {
+			set.specific[domain] = append(set.specific[domain], rule)
+		}
+	}
+}
+
+// Selectors returns the sorted CSS selectors to hide on pages of the hostname.
+// Procedural rules are left out, see ProceduralRules.
+func (set *CosmeticSet) Selectors(hostname string) []string {
+	return set.selectors(hostname, true)
+}
+
+// SpecificSelectors returns the sorted CSS selectors to hide on pages of the
+// hostname, leaving out generic rules as $generichide exceptions ask for
+func (set *CosmeticSet) SpecificSelectors(hostname string) []string {
+	return set.selectors(hostname, false)
+}
+
+func (set *CosmeticSet) selectors(hostname string, generic bool) []string {
+	var selectors []string
+	for _, rule := range set.rules(hostname, generic) {
+		if rule.procedural == nil {
+			selectors = append(selectors, rule.selector)
+		}
+	}
+	return selectors
+}
+
+// ProceduralRules returns the rules with extended selectors to apply on pages
+// of the hostname, sorted by selector
+func (set *CosmeticSet) ProceduralRules(hostname string) []*CosmeticRule {
+	var rules []*CosmeticRule
+	for _, rule := range set.rules(hostname, true) {
+		if rule.procedural != nil {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+// rules returns the rules to apply on pages of the hostname, one for each
+// selector, sorted by selector
+func (set *CosmeticSet) rules(hostname string, generic bool) []*CosmeticRule {
+	hostname = normalizeHostname(hostname)
+	found := map[string]*CosmeticRule{}
+	add := func(rule *CosmeticRule) {
+		if _, ok := found[rule.selector]; !ok && rule.appliesTo(hostname) && !set.isExcepted(rule.selector, hostname) {
+			found[rule.selector] = rule
+		}
+	}
+
+	if generic {
+		for _, rule := range set.generic {
+			add(rule)
+		}
+	}
+	for _, domain := range hostnameDomains(hostname) {
+		for _, rule := range set.specific[domain] {
+			add(rule)
+		}
+	}
+
+	rules := make([]*CosmeticRule, 0, len(found))
+	for _, rule := range found {
+		rules = append(rules, rule)
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		return rules[i].selector < rules[j].selector
+	})
+	return rules
+}
+
+func (set *CosmeticSet) isExcepted(selector, hostname string) bool {
+	for _, exception := range set.exceptions[selector] {
+		if exception.appliesTo(hostname) {
+			return true
+		}
+	}
+	return false
+}
+
+// hostnameDomains returns the domains a hostname belongs to, and the same
+// without public suffix as entities like example.*
+func hostnameDomains(hostname string) []string {
+	domains := labelSuffixes(hostname)
+	suffix, _ := publicsuffix.PublicSuffix(hostname)
+	if base := strings.TrimSuffix(hostname, "."+suffix); base != hostname {
+		for _, name := range labelSuffixes(base) {
+			domains = append(domains, name+".*")
+		}
+	}
+	return domains
+}
+
+// labelSuffixes returns the hostname and all its parent domains
+func labelSuffixes(hostname string) []string {
+	names := []string{hostname}
+	for index := strings.Index(hostname, "."); index >= 0; index = strings.Index(hostname, ".") {
+		hostname = hostname[index+1:]
+		names = append(names, hostname)
+	}
+	return names
+}
@@ -0,0 +1,81 @@
+package adblockgoparser
+
+import (
+	"io"
+	"net/url"
+
+	"github.com/andybalholm/cascadia"
+	"golang.org/x/net/html"
+)
+
+// HiddenAttribute is set by HideAnnotate on the elements matched by element
+// hiding rules, with the rule as value
+const HiddenAttribute = "data-adblock-hidden"
+
+// HideMode tells what to do with the elements matched by element hiding rules
+type HideMode int
+
+const (
+	// HideRemove removes the elements from the document
+	HideRemove HideMode = iota
+	// HideAnnotate keeps the elements, setting HiddenAttribute on them
+	HideAnnotate
+)
+
+// HiddenElement is an element matched by an element hiding rule
+type HiddenElement struct {
+	// Rule which matched the element
+	Rule *CosmeticRule
+	// Node of the element, detached from the document with HideRemove
+	Node *html.Node
+}
+
+// StripHTML parses an HTML document and hides the elements matched by the
+// rules for the page URL. Selectors which cannot be evaluated on static HTML
+// are ignored.
+func (set *CosmeticSet) StripHTML(r io.Reader, pageURL *url.URL, exceptions PageExceptions, mode HideMode) (*html.Node, []HiddenElement, error) {
+	doc, err := html.Parse(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	return doc, set.Hide(doc, pageURL, exceptions, mode), nil
+}
+
+// Hide hides the elements of a parsed HTML document matched by the rules for
+// the page URL, and returns them in document order for each rule. The page
+// exceptions, from RuleSet.PageExceptions, disable all the rules or the
+// generic ones.
+func (set *CosmeticSet) Hide(doc *html.Node, pageURL *url.URL, exceptions PageExceptions, mode HideMode) []HiddenElement {
+	if exceptions.CosmeticDisabled() {
+		return nil
+	}
+	var hidden []HiddenElement
+	seen := map[*html.Node]struct{}{}
+	for _, rule := range set.rules(pageURL.Hostname(), !exceptions.GenericHide) {
+		var nodes []*html.Node
+		if rule.procedural != nil {
+			nodes = rule.procedural.MatchAll(doc)
+		} else if selector, err := cascadia.Compile(rule.selector); err == nil {
+			nodes = selector.MatchAll(doc)
+		}
+		for _, node := range nodes {
+			if _, ok := seen[node]; ok {
+				continue
+			}
+			seen[node] = struct{}{}
+			hidden = append(hidden, HiddenElement{Rule: rule, Node: node})
+		}
+	}
+
+	for _, element := range hidden {
+		switch mode {
+		case HideRemove:
+			if element.Node.Parent != nil {
+				element.Node.Parent.RemoveChild(element.Node)
+			}
+		case HideAnnotate:
+			element.Node.Attr = append(element.Node.Attr, html.Attribute{Key: HiddenAttribute, Val: element.Rule.String()})
+		}
+	}
+	return hidden
+}
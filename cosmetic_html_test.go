@@ -0,0 +1,81 @@
+package adblockgoparser
+
+import (
+	"bytes"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/html"
+)
+
+const testDocument = `<html><head></head><body>` +
+	`<div id="content"><p>Article</p><div class="ad-banner"><img src="ad.gif"></div></div>` +
+	`<aside id="sidebar"><div class="sponsored">Buy</div></aside>` +
+	`<div class="ad-banner">Second</div>` +
+	`</body></html>`
+
+func renderHTML(t *testing.T, node *html.Node) string {
+	var buf bytes.Buffer
+	assert.NoError(t, html.Render(&buf, node))
+	return buf.String()
+}
+
+func TestStripHTMLRemove(t *testing.T) {
+	set := newCosmeticSetFromList(t, []string{
+		"##.ad-banner",
+		"example.com###sidebar > .sponsored",
+		"##.sponsored:hover",
+	})
+	pageURL, _ := url.Parse("http://www.example.com/article")
+
+	doc, hidden, err := set.StripHTML(strings.NewReader(testDocument), pageURL, PageExceptions{}, HideRemove)
+	assert.NoError(t, err)
+	assert.Equal(t, `<html><head></head><body>`+
+		`<div id="content"><p>Article</p></div>`+
+		`<aside id="sidebar"></aside>`+
+		`</body></html>`, renderHTML(t, doc))
+
+	assert.Len(t, hidden, 3)
+	assert.Equal(t, "#sidebar > .sponsored", hidden[0].Rule.Selector())
+	assert.Equal(t, "sponsored", hidden[0].Node.Attr[0].Val)
+	assert.Equal(t, ".ad-banner", hidden[1].Rule.Selector())
+	assert.Equal(t, ".ad-banner", hidden[2].Rule.Selector())
+	assert.Equal(t, "Second", hidden[2].Node.FirstChild.Data)
+}
+
+func TestStripHTMLAnnotate(t *testing.T) {
+	set := newCosmeticSetFromList(t, []string{"##.ad-banner", "example.com###sidebar > .sponsored", "example.com#@#.ad-banner"})
+	pageURL, _ := url.Parse("http://other.com/")
+
+	doc, hidden, err := set.StripHTML(strings.NewReader(testDocument), pageURL, PageExceptions{}, HideAnnotate)
+	assert.NoError(t, err)
+	assert.Len(t, hidden, 2)
+	assert.Equal(t, `<html><head></head><body>`+
+		`<div id="content"><p>Article</p><div class="ad-banner" data-adblock-hidden="##.ad-banner"><img src="ad.gif"/></div></div>`+
+		`<aside id="sidebar"><div class="sponsored">Buy</div></aside>`+
+		`<div class="ad-banner" data-adblock-hidden="##.ad-banner">Second</div>`+
+		`</body></html>`, renderHTML(t, doc))
+
+	// Exceptions are applied
+	pageURL, _ = url.Parse("http://example.com/")
+	_, hidden, err = set.StripHTML(strings.NewReader(testDocument), pageURL, PageExceptions{}, HideAnnotate)
+	assert.NoError(t, err)
+	assert.Len(t, hidden, 1)
+	assert.Equal(t, "example.com###sidebar > .sponsored", hidden[0].Rule.Text())
+}
+
+func TestStripHTMLPageExceptions(t *testing.T) {
+	set := newCosmeticSetFromList(t, []string{"##.ad-banner", "example.com###sidebar > .sponsored"})
+	pageURL, _ := url.Parse("http://example.com/")
+
+	_, hidden, err := set.StripHTML(strings.NewReader(testDocument), pageURL, PageExceptions{GenericHide: true}, HideAnnotate)
+	assert.NoError(t, err)
+	assert.Len(t, hidden, 1)
+	assert.Equal(t, "example.com###sidebar > .sponsored", hidden[0].Rule.Text())
+
+	_, hidden, err = set.StripHTML(strings.NewReader(testDocument), pageURL, PageExceptions{ElemHide: true}, HideRemove)
+	assert.NoError(t, err)
+	assert.Empty(t, hidden)
+}
@@ -0,0 +1,611 @@
+package adblockgoparser
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/cascadia"
+	"github.com/antchfx/htmlquery"
+	"github.com/antchfx/xpath"
+	"golang.org/x/net/html"
+)
+
+// ProceduralOperator is a step of a procedural selector
+type ProceduralOperator int
+
+const (
+	// OperatorCSS selects elements with a CSS selector, relative to the
+	// current ones when it starts with a combinator
+	OperatorCSS ProceduralOperator = iota
+	// OperatorHas keeps the elements containing elements matching Arg,
+	// written :has() or :-abp-has()
+	OperatorHas
+	// OperatorNot keeps the elements not matching Arg
+	OperatorNot
+	// OperatorHasText keeps the elements whose text contains Text or matches
+	// Regex, written :has-text() or :-abp-contains()
+	OperatorHasText
+	// OperatorUpward replaces the elements by an ancestor, Levels up or the
+	// closest matching Selector
+	OperatorUpward
+	// OperatorXPath replaces the elements by the result of an XPath expression
+	OperatorXPath
+)
+
+// ErrBadSelector Procedural selectors which cannot be parsed are skipped
+var ErrBadSelector = errors.New("Cannot parse procedural selector")
+
+var (
+	proceduralOperators = map[string]ProceduralOperator{
+		"has":           OperatorHas,
+		"-abp-has":      OperatorHas,
+		"if":            OperatorHas,
+		"not":           OperatorNot,
+		"if-not":        OperatorNot,
+		"has-text":      OperatorHasText,
+		"-abp-contains": OperatorHasText,
+		"upward":        OperatorUpward,
+		"nth-ancestor":  OperatorUpward,
+		"xpath":         OperatorXPath,
+	}
+	// Operators which need a browser to be evaluated
+	unsupportedOperators = map[string]struct{}{
+		"matches-css":        {},
+		"matches-css-before": {},
+		"matches-css-after":  {},
+		"-abp-properties":    {},
+		"min-text-length":    {},
+		"watch-attr":         {},
+		"matches-path":       {},
+		"matches-attr":       {},
+		"matches-prop":       {},
+		"others":             {},
+		"style":              {},
+	}
+)
+
+// ProceduralStep is an operator of a procedural selector with its argument
+type ProceduralStep struct {
+	Operator ProceduralOperator
+	// Selector of OperatorCSS and OperatorUpward
+	Selector string
+	// Arg of OperatorHas and OperatorNot
+	Arg *ProceduralSelector
+	// Text of OperatorHasText, when it is not a regex
+	Text string
+	// Regex of OperatorHasText
+	Regex *regexp.Regexp
+	// Levels of OperatorUpward, when it has no selector
+	Levels int
+	// XPath expression of OperatorXPath
+	XPath string
+
+	css      cascadia.Sel
+	relative [][]compound
+	xpath    *xpath.Expr
+}
+
+// compound is a part of a relative selector, with the combinator that relates
+// it to the elements matched by the previous part
+type compound struct {
+	combinator byte
+	sel        cascadia.Sel
+}
+
+// ProceduralSelector extended selector, the steps are applied in order
+// starting from the document
+type ProceduralSelector struct {
+	Steps []*ProceduralStep
+	// Remove tells the elements are removed instead of hidden, from :remove()
+	Remove bool
+}
+
+// isProcedural tells if the selector uses extended syntax
+func isProcedural(selector string) bool {
+	for name := range proceduralOperators {
+		if strings.Contains(selector, ":"+name+"(") {
+			return true
+		}
+	}
+	for name := range unsupportedOperators {
+		if strings.Contains(selector, ":"+name+"(") {
+			return true
+		}
+	}
+	return strings.Contains(selector, ":remove(")
+}
+
+// ParseProceduralSelector parses an extended selector, like
+// div:has(> .ad):upward(2) or .post:has-text(/sponsored/i)
+func ParseProceduralSelector(selector string) (*ProceduralSelector, error) {
+	procedural := &ProceduralSelector{}
+	start := 0
+	depth := 0
+	var quote byte
+	for i := 0; i < len(selector); i++ {
+		c := selector[i]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+			continue
+		case c == '"' || c == '\'':
+			quote = c
+			continue
+		case c == '(' || c == '[':
+			depth++
+			continue
+		case c == ')' || c == ']':
+			depth--
+			continue
+		case c != ':' || depth != 0:
+			continue
+		}
+
+		name := operatorName(selector[i+1:])
+		open := i + 1 + len(name)
+		if open >= len(selector) || selector[open] != '(' {
+			continue
+		}
+		_, supported := proceduralOperators[name]
+		if _, unsupported := unsupportedOperators[name]; unsupported {
+			return nil, fmt.Errorf("%w: unsupported operator :%s()", ErrBadSelector, name)
+		}
+		if !supported && name != "remove" {
+			continue
+		}
+
+		end := closingParen(selector, open)
+		if end < 0 {
+			return nil, fmt.Errorf("%w: unbalanced parentheses", ErrBadSelector)
+		}
+		// Plain CSS :not() is left to the CSS selector
+		if name == "not" && !isProcedural(selector[open+1:end]) {
+			continue
+		}
+		if procedural.Remove {
+			return nil, fmt.Errorf("%w: :remove() must be the last operator", ErrBadSelector)
+		}
+		if err := procedural.addCSS(selector[start:i]); err != nil {
+			return nil, err
+		}
+		if name == "remove" {
+			if strings.TrimSpace(selector[open+1:end]) != "" {
+				return nil, fmt.Errorf("%w: :remove() takes no argument", ErrBadSelector)
+			}
+			procedural.Remove = true
+		} else {
+			step, err := parseStep(proceduralOperators[name], strings.TrimSpace(selector[open+1:end]))
+			if err != nil {
+				return nil, err
+			}
+			procedural.Steps = append(procedural.Steps, step)
+		}
+		i = end
+		start = end + 1
+	}
+	if quote != 0 || depth != 0 {
+		return nil, fmt.Errorf("%w: unbalanced selector", ErrBadSelector)
+	}
+	if strings.TrimSpace(selector[start:]) != "" && procedural.Remove {
+		return nil, fmt.Errorf("%w: :remove() must be the last operator", ErrBadSelector)
+	}
+	if err := procedural.addCSS(selector[start:]); err != nil {
+		return nil, err
+	}
+	if len(procedural.Steps) == 0 {
+		return nil, fmt.Errorf("%w: empty selector", ErrBadSelector)
+	}
+	return procedural, nil
+}
+
+func operatorName(text string) string {
+	end := 0
+	for end < len(text) && (text[end] == '-' || text[end] >= 'a' && text[end] <= 'z') {
+		end++
+	}
+	return text[:end]
+}
+
+// closingParen returns the index of the parenthesis closing the one at open
+func closingParen(text string, open int) int {
+	depth := 0
+	var quote byte
+	for i := open; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			// Quotes only delimit strings inside selectors, not in text or regex arguments
+			if i > 0 && (text[i-1] == '=' || text[i-1] == '(' || text[i-1] == ' ') {
+				quote = c
+			}
+		case c == '\\':
+			i++
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func (procedural *ProceduralSelector) addCSS(selector string) error {
+	selector = strings.TrimSpace(selector)
+	if selector == "" {
+		return nil
+	}
+	step := &ProceduralStep{Operator: OperatorCSS, Selector: selector}
+	// Selectors applied to the document can't start with a combinator, the
+	// relative one is applied to the elements found by the previous steps
+	if !strings.ContainsAny(selector[:1], "+~>") {
+		css, err := cascadia.Parse(selector)
+		if err != nil {
+			return &causeError{reason: ErrBadSelector, cause: err}
+		}
+		step.css = css
+	}
+	for _, alternative := range splitTopLevel(selector, ",") {
+		chain, err := parseRelative(alternative)
+		if err != nil {
+			return err
+		}
+		step.relative = append(step.relative, chain)
+	}
+	procedural.Steps = append(procedural.Steps, step)
+	return nil
+}
+
+// parseRelative splits a selector on its combinators, the first part is
+// related to the scope element by its leading combinator or is a descendant
+func parseRelative(selector string) ([]compound, error) {
+	var chain []compound
+	combinator := byte(' ')
+	for _, part := range splitTopLevel(selector, " >+~") {
+		if len(part) == 1 && strings.Contains(">+~", part) {
+			combinator = part[0]
+			continue
+		}
+		sel, err := cascadia.Parse(part)
+		if err != nil {
+			return nil, &causeError{reason: ErrBadSelector, cause: err}
+		}
+		chain = append(chain, compound{combinator: combinator, sel: sel})
+		combinator = ' '
+	}
+	if len(chain) == 0 || combinator != ' ' {
+		return nil, fmt.Errorf("%w: bad combinator in %q", ErrBadSelector, selector)
+	}
+	return chain, nil
+}
+
+// splitTopLevel splits the text on the separators found outside of brackets,
+// parentheses and strings. Separators other than spaces and commas are kept
+// as parts.
+func splitTopLevel(text string, separators string) []string {
+	var parts []string
+	depth := 0
+	start := 0
+	var quote byte
+	add := func(part string) {
+		if part = strings.TrimSpace(part); part != "" {
+			parts = append(parts, part)
+		}
+	}
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '(' || c == '[':
+			depth++
+		case c == ')' || c == ']':
+			depth--
+		case depth == 0 && strings.IndexByte(separators, c) >= 0:
+			add(text[start:i])
+			if c != ' ' && c != ',' {
+				parts = append(parts, string(c))
+			}
+			start = i + 1
+		}
+	}
+	add(text[start:])
+	return parts
+}
+
+func parseStep(operator ProceduralOperator, arg string) (*ProceduralStep, error) {
+	step := &ProceduralStep{Operator: operator}
+	switch operator {
+	case OperatorHas, OperatorNot:
+		procedural, err := ParseProceduralSelector(arg)
+		if err != nil {
+			return nil, err
+		}
+		step.Arg = procedural
+	case OperatorHasText:
+		if end := strings.LastIndex(arg, "/"); strings.HasPrefix(arg, "/") && end > 0 {
+			flags := arg[end+1:]
+			if strings.Trim(flags, "imsu") != "" {
+				return nil, fmt.Errorf("%w: bad regex flags %q", ErrBadSelector, flags)
+			}
+			pattern := arg[1:end]
+			if flags = strings.Trim(flags, "u"); flags != "" {
+				pattern = "(?" + flags + ")" + pattern
+			}
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, &causeError{reason: ErrBadSelector, cause: err}
+			}
+			step.Regex = re
+		} else {
+			step.Text = arg
+		}
+	case OperatorUpward:
+		if levels, err := strconv.Atoi(arg); err == nil {
+			if levels < 1 || levels > 256 {
+				return nil, fmt.Errorf("%w: bad upward levels %d", ErrBadSelector, levels)
+			}
+			step.Levels = levels
+			break
+		}
+		css, err := cascadia.Parse(arg)
+		if err != nil {
+			return nil, &causeError{reason: ErrBadSelector, cause: err}
+		}
+		step.Selector = arg
+		step.css = css
+	case OperatorXPath:
+		expr, err := xpath.Compile(arg)
+		if err != nil {
+			return nil, &causeError{reason: ErrBadSelector, cause: err}
+		}
+		step.XPath = arg
+		step.xpath = expr
+	}
+	return step, nil
+}
+
+// MatchAll returns the elements of the document matched by the selector
+func (procedural *ProceduralSelector) MatchAll(doc *html.Node) []*html.Node {
+	return procedural.apply([]*html.Node{doc})
+}
+
+func (procedural *ProceduralSelector) apply(nodes []*html.Node) []*html.Node {
+	for i, step := range procedural.Steps {
+		// Filters at the start apply to all the elements
+		if i == 0 && (step.Operator == OperatorHas || step.Operator == OperatorNot || step.Operator == OperatorHasText) {
+			var elements []*html.Node
+			for _, node := range nodes {
+				elements = append(elements, descendants(node)...)
+			}
+			nodes = elements
+		}
+		nodes = uniqueNodes(step.apply(nodes))
+		if len(nodes) == 0 {
+			break
+		}
+	}
+	return nodes
+}
+
+func (step *ProceduralStep) apply(nodes []*html.Node) []*html.Node {
+	// The elements :not() excludes and the text of the elements are found
+	// once for all the nodes
+	var excluded map[*html.Node]struct{}
+	if step.Operator == OperatorNot && len(nodes) > 0 {
+		excluded = map[*html.Node]struct{}{}
+		for _, node := range step.Arg.MatchAll(documentRoot(nodes[0])) {
+			excluded[node] = struct{}{}
+		}
+	}
+	var texts *textIndex
+	if step.Operator == OperatorHasText && len(nodes) > 0 {
+		texts = newTextIndex(documentRoot(nodes[0]))
+	}
+
+	var result []*html.Node
+	for _, node := range nodes {
+		switch step.Operator {
+		case OperatorCSS:
+			result = append(result, step.matchCSS(node)...)
+		case OperatorHas:
+			if len(step.Arg.apply([]*html.Node{node})) > 0 {
+				result = append(result, node)
+			}
+		case OperatorNot:
+			if _, ok := excluded[node]; !ok {
+				result = append(result, node)
+			}
+		case OperatorHasText:
+			text := texts.text(node)
+			if step.Regex != nil && step.Regex.MatchString(text) || step.Regex == nil && strings.Contains(text, step.Text) {
+				result = append(result, node)
+			}
+		case OperatorUpward:
+			if ancestor := step.upward(node); ancestor != nil {
+				result = append(result, ancestor)
+			}
+		case OperatorXPath:
+			for _, found := range htmlquery.QuerySelectorAll(node, step.xpath) {
+				if found.Type == html.ElementNode {
+					result = append(result, found)
+				}
+			}
+		}
+	}
+	return result
+}
+
+// matchCSS selects the elements related to the node, its descendants by
+// default or the ones given by the leading combinator. Selectors are applied
+// to the whole document, or part by part from the node as scope.
+func (step *ProceduralStep) matchCSS(node *html.Node) []*html.Node {
+	if node.Type == html.DocumentNode && step.css != nil {
+		return cascadia.QueryAll(node, step.css)
+	}
+
+	var matched []*html.Node
+	for _, chain := range step.relative {
+		nodes := []*html.Node{node}
+		for _, part := range chain {
+			nodes = part.match(nodes)
+		}
+		matched = append(matched, nodes...)
+	}
+	return matched
+}
+
+// match returns the elements related to the nodes by the combinator and
+// matching the selector, each one once
+func (part compound) match(nodes []*html.Node) []*html.Node {
+	var matched []*html.Node
+	seen := map[*html.Node]struct{}{}
+	add := func(candidate *html.Node) bool {
+		if _, ok := seen[candidate]; ok {
+			return false
+		}
+		seen[candidate] = struct{}{}
+		if part.sel.Match(candidate) {
+			matched = append(matched, candidate)
+		}
+		return true
+	}
+	for _, node := range nodes {
+		switch part.combinator {
+		case '>':
+			for child := node.FirstChild; child != nil; child = child.NextSibling {
+				if child.Type == html.ElementNode {
+					add(child)
+				}
+			}
+		case '+':
+			if sibling := nextElement(node); sibling != nil {
+				add(sibling)
+			}
+		case '~':
+			// Stops at the siblings already walked from a previous node
+			for sibling := nextElement(node); sibling != nil && add(sibling); sibling = nextElement(sibling) {
+			}
+		default:
+			if _, ok := seen[node]; !ok {
+				addDescendants(node, add)
+			}
+		}
+	}
+	return matched
+}
+
+// addDescendants walks the elements under the node, skipping the subtrees
+// already walked
+func addDescendants(node *html.Node, add func(*html.Node) bool) {
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type == html.ElementNode && !add(child) {
+			continue
+		}
+		addDescendants(child, add)
+	}
+}
+
+func nextElement(node *html.Node) *html.Node {
+	for sibling := node.NextSibling; sibling != nil; sibling = sibling.NextSibling {
+		if sibling.Type == html.ElementNode {
+			return sibling
+		}
+	}
+	return nil
+}
+
+func (step *ProceduralStep) upward(node *html.Node) *html.Node {
+	levels := 0
+	for ancestor := node.Parent; ancestor != nil && ancestor.Type == html.ElementNode; ancestor = ancestor.Parent {
+		levels++
+		if step.css != nil && step.css.Match(ancestor) || step.css == nil && levels == step.Levels {
+			return ancestor
+		}
+	}
+	return nil
+}
+
+// documentRoot returns the top node of the tree the node belongs to
+func documentRoot(node *html.Node) *html.Node {
+	for node.Parent != nil {
+		node = node.Parent
+	}
+	return node
+}
+
+// descendants returns the elements under the node in document order
+func descendants(node *html.Node) []*html.Node {
+	var nodes []*html.Node
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type == html.ElementNode {
+			nodes = append(nodes, child)
+		}
+		nodes = append(nodes, descendants(child)...)
+	}
+	return nodes
+}
+
+// textIndex holds the text of a document, the text of each node is a part of
+// it
+type textIndex struct {
+	content string
+	spans   map[*html.Node][2]int
+}
+
+func newTextIndex(root *html.Node) *textIndex {
+	var text strings.Builder
+	spans := map[*html.Node][2]int{}
+	var walk func(node *html.Node)
+	walk = func(node *html.Node) {
+		start := text.Len()
+		if node.Type == html.TextNode {
+			text.WriteString(node.Data)
+		}
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+		spans[node] = [2]int{start, text.Len()}
+	}
+	walk(root)
+	return &textIndex{content: text.String(), spans: spans}
+}
+
+// text returns the text content of the node
+func (index *textIndex) text(node *html.Node) string {
+	span := index.spans[node]
+	return index.content[span[0]:span[1]]
+}
+
+func uniqueNodes(nodes []*html.Node) []*html.Node {
+	seen := map[*html.Node]struct{}{}
+	unique := nodes[:0]
+	for _, node := range nodes {
+		if _, ok := seen[node]; !ok {
+			seen[node] = struct{}{}
+			unique = append(unique, node)
+		}
+	}
+	return unique
+}
@@ -0,0 +1,220 @@
+package adblockgoparser
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/html"
+)
+
+const proceduralDocument = `<html><head></head><body>` +
+	`<div class="post"><p>News</p></div>` +
+	`<div class="post"><p>Sponsored content</p><span class="label">Ad</span></div>` +
+	`<div class="post"><section><p>PROMOTED</p></section></div>` +
+	`</body></html>`
+
+func matchProcedural(t *testing.T, selector string) []string {
+	procedural, err := ParseProceduralSelector(selector)
+	assert.NoError(t, err)
+	if err != nil {
+		return nil
+	}
+	doc, err := html.Parse(strings.NewReader(proceduralDocument))
+	assert.NoError(t, err)
+
+	return nodeTexts(procedural.MatchAll(doc))
+}
+
+func nodeTexts(nodes []*html.Node) []string {
+	var texts []string
+	for _, node := range nodes {
+		texts = append(texts, newTextIndex(node).text(node))
+	}
+	return texts
+}
+
+func TestParseProceduralSelector(t *testing.T) {
+	procedural, err := ParseProceduralSelector("div.post:-abp-has(> span.label):upward(2):remove()")
+	assert.NoError(t, err)
+	assert.True(t, procedural.Remove)
+	assert.Len(t, procedural.Steps, 3)
+	assert.Equal(t, OperatorCSS, procedural.Steps[0].Operator)
+	assert.Equal(t, "div.post", procedural.Steps[0].Selector)
+	assert.Equal(t, OperatorHas, procedural.Steps[1].Operator)
+	assert.Equal(t, "> span.label", procedural.Steps[1].Arg.Steps[0].Selector)
+	assert.Equal(t, OperatorUpward, procedural.Steps[2].Operator)
+	assert.Equal(t, 2, procedural.Steps[2].Levels)
+
+	procedural, err = ParseProceduralSelector(`p:has-text(/sponsored|promoted/i):upward(.post)`)
+	assert.NoError(t, err)
+	assert.Equal(t, OperatorHasText, procedural.Steps[1].Operator)
+	assert.Equal(t, "(?i)sponsored|promoted", procedural.Steps[1].Regex.String())
+	assert.Equal(t, ".post", procedural.Steps[2].Selector)
+
+	procedural, err = ParseProceduralSelector(`:xpath(//div[@class="post"])`)
+	assert.NoError(t, err)
+	assert.Equal(t, OperatorXPath, procedural.Steps[0].Operator)
+	assert.Equal(t, `//div[@class="post"]`, procedural.Steps[0].XPath)
+
+	procedural, err = ParseProceduralSelector("div:not(.post) > p")
+	assert.NoError(t, err)
+	assert.Len(t, procedural.Steps, 1)
+	assert.Equal(t, "div:not(.post) > p", procedural.Steps[0].Selector)
+
+	for _, selector := range []string{
+		"div:has(> p",
+		"div:matches-css(display: none)",
+		"div:upward(0)",
+		"div:has-text(/(/)",
+		"div:remove():has(p)",
+		"div:remove(p)",
+		":xpath(//div[)",
+	} {
+		_, err := ParseProceduralSelector(selector)
+		assert.True(t, errors.Is(err, ErrBadSelector), selector)
+	}
+}
+
+func TestProceduralMatchAll(t *testing.T) {
+	assert.Equal(t, []string{"Sponsored contentAd"}, matchProcedural(t, ".post:has(> span.label)"))
+	assert.Equal(t, []string{"Sponsored contentAd"}, matchProcedural(t, ".post:-abp-has(.label)"))
+	assert.Equal(t, []string{"PROMOTED"}, matchProcedural(t, ".post:has(p:has-text(PROMOTED))"))
+	assert.Equal(t, []string{"Sponsored contentAd", "PROMOTED"}, matchProcedural(t, ".post:has-text(/sponsored|promoted/i)"))
+	assert.Equal(t, []string{"News"}, matchProcedural(t, ".post:not(:has-text(/sponsored|promoted/i))"))
+	assert.Equal(t, []string{"PROMOTED"}, matchProcedural(t, "p:-abp-contains(PROMOTED):upward(2)"))
+	assert.Equal(t, []string{"PROMOTED"}, matchProcedural(t, "p:has-text(PROMOTED):upward(.post)"))
+	assert.Equal(t, []string{"Sponsored contentAd"}, matchProcedural(t, `:xpath(//span[@class="label"]/..)`))
+	assert.Equal(t, []string{"Ad"}, matchProcedural(t, "p:has-text(Sponsored) + span"))
+	assert.Empty(t, matchProcedural(t, "p:has-text(Nothing)"))
+}
+
+func TestProceduralRelativeSelectors(t *testing.T) {
+	doc, err := html.Parse(strings.NewReader(`<html><head></head><body>` +
+		`<section id="a"><div><p class="ad">A</p></div></section>` +
+		`<section id="b"><p class="ad">B</p></section>` +
+		`<section id="c"><span><div><p class="ad">C</p></div></span></section>` +
+		`<h2>Title</h2><div class="box"><a href="/ad"><img src="ad.gif"></a></div>` +
+		`</body></html>`))
+	assert.NoError(t, err)
+
+	match := func(selector string) []string {
+		procedural, err := ParseProceduralSelector(selector)
+		assert.NoError(t, err, selector)
+		return nodeTexts(procedural.MatchAll(doc))
+	}
+
+	assert.Equal(t, []string{"A"}, match("section:has(> div > .ad)"))
+	assert.Equal(t, []string{"A", "C"}, match("section:has(div .ad)"))
+	assert.Equal(t, []string{"A"}, match("section:has(> div .ad)"))
+	assert.Empty(t, match("section:has(> .ad > div)"))
+	assert.Equal(t, []string{"Title"}, match("h2:has(+ div.box > a > img)"))
+	assert.Equal(t, []string{"Title"}, match("h2:has(~ div a[href] img)"))
+	assert.Empty(t, match("h2:has(+ div.box > img)"))
+	assert.Equal(t, []string{"C"}, match("section:has-text(C) span > div"))
+	// Scoped selectors don't reach above the scope
+	assert.Empty(t, match("section:has-text(A) body p"))
+}
+
+func TestProceduralNotLargeDocument(t *testing.T) {
+	var page strings.Builder
+	page.WriteString("<html><body>")
+	for i := 0; i < 3000; i++ {
+		page.WriteString("<div>item</div>")
+	}
+	page.WriteString("<div>zzz</div></body></html>")
+	doc, err := html.Parse(strings.NewReader(page.String()))
+	assert.NoError(t, err)
+
+	procedural, err := ParseProceduralSelector("div:not(:has-text(zzz))")
+	assert.NoError(t, err)
+	assert.Len(t, procedural.MatchAll(doc), 3000)
+}
+
+func TestProceduralNestedDocument(t *testing.T) {
+	var page strings.Builder
+	page.WriteString("<html><body>")
+	for i := 0; i < 2000; i++ {
+		page.WriteString("<div><span>item</span>")
+	}
+	page.WriteString(`<div><span class="ad">zzz</span></div>`)
+	page.WriteString(strings.Repeat("</div>", 2000) + "</body></html>")
+	doc, err := html.Parse(strings.NewReader(page.String()))
+	assert.NoError(t, err)
+
+	procedural, err := ParseProceduralSelector("div:has(> span.ad)")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"zzz"}, nodeTexts(procedural.MatchAll(doc)))
+
+	procedural, err = ParseProceduralSelector("div:has-text(zzz)")
+	assert.NoError(t, err)
+	assert.Len(t, procedural.MatchAll(doc), 2001)
+
+	procedural, err = ParseProceduralSelector("body > div:has-text(zzz) span ~ div > span.ad")
+	assert.NoError(t, err)
+	assert.Len(t, procedural.MatchAll(doc), 1)
+}
+
+func TestParseExtendedCosmeticRule(t *testing.T) {
+	rule, err := ParseCosmeticRule("example.com#?#.post:has(.label)")
+	assert.NoError(t, err)
+	assert.False(t, rule.IsException())
+	assert.Equal(t, ".post:has(.label)", rule.Selector())
+	assert.NotNil(t, rule.Procedural())
+	assert.Equal(t, "example.com#?#.post:has(.label)", rule.String())
+
+	rule, err = ParseCosmeticRule("example.com#@?#.post:has(.label)")
+	assert.NoError(t, err)
+	assert.True(t, rule.IsException())
+	assert.Equal(t, "example.com#@?#.post:has(.label)", rule.String())
+
+	// Procedural operators are also found in regular rules
+	rule, err = ParseCosmeticRule("##.post:has-text(Sponsored)")
+	assert.NoError(t, err)
+	assert.NotNil(t, rule.Procedural())
+
+	rule, err = ParseCosmeticRule("#?#.ad:not(.keep)")
+	assert.NoError(t, err)
+	assert.Nil(t, rule.Procedural())
+
+	_, err = ParseCosmeticRule("#?#.ad:matches-css(display: block)")
+	assert.True(t, errors.Is(err, ErrBadSelector))
+	parseErr := &ParseError{}
+	assert.True(t, errors.As(err, &parseErr))
+	assert.Equal(t, "#?#.ad:matches-css(display: block)", parseErr.Rule)
+}
+
+func TestProceduralRules(t *testing.T) {
+	set := newCosmeticSetFromList(t, []string{
+		"##.ad",
+		"example.com#?#.post:has(.label)",
+		"#?#.post:has-text(PROMOTED)",
+		"example.com#@?#.post:has-text(PROMOTED)",
+	})
+	assert.Equal(t, []string{".ad"}, set.Selectors("example.com"))
+
+	rules := set.ProceduralRules("example.com")
+	assert.Len(t, rules, 1)
+	assert.Equal(t, ".post:has(.label)", rules[0].Selector())
+
+	rules = set.ProceduralRules("other.com")
+	assert.Len(t, rules, 1)
+	assert.Equal(t, ".post:has-text(PROMOTED)", rules[0].Selector())
+}
+
+func TestHideProcedural(t *testing.T) {
+	set := newCosmeticSetFromList(t, []string{
+		"#?#.post:has(> .label)",
+		"##p:has-text(PROMOTED):upward(.post):remove()",
+	})
+	pageURL, _ := url.Parse("http://example.com/")
+
+	doc, hidden, err := set.StripHTML(strings.NewReader(proceduralDocument), pageURL, PageExceptions{}, HideRemove)
+	assert.NoError(t, err)
+	assert.Len(t, hidden, 2)
+	assert.Equal(t, `<html><head></head><body>`+
+		`<div class="post"><p>News</p></div>`+
+		`</body></html>`, renderHTML(t, doc))
+}
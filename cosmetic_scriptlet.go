@@ -0,0 +1,298 @@
+package adblockgoparser
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// Separators and bodies of scriptlet rules, uBlock Origin writes them
+// example.com##+js(name, arg) and AdGuard example.com#%#//scriptlet('name', 'arg')
+var scriptletSyntaxes = []struct {
+	separator   string
+	prefix      string
+	isException bool
+	isAdGuard   bool
+}{
+	{"#@%#", "//scriptlet(", true, true},
+	{"#%#", "//scriptlet(", false, true},
+	{"#@#", "+js(", true, false},
+	{"##", "+js(", false, false},
+}
+
+// ScriptletRule scriptlet injection rule, running a script from the library of
+// the browser with some arguments on some pages
+type ScriptletRule struct {
+	rawText     string
+	source      string
+	name        string
+	args        []string
+	domains     map[string]bool
+	isException bool
+	isAdGuard   bool
+}
+
+// isScriptletRule tells if the text is written as a scriptlet rule
+func isScriptletRule(ruleText string) bool {
+	for _, syntax := range scriptletSyntaxes {
+		if strings.Contains(ruleText, syntax.separator+syntax.prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseScriptletRule parse and create a ScriptletRule, like
+// example.com##+js(set-constant, ads, false) or
+// example.com#%#//scriptlet('set-constant', 'ads', 'false')
+func ParseScriptletRule(ruleText string) (*ScriptletRule, error) {
+	ruleText = strings.TrimSpace(ruleText)
+	if ruleText == "" {
+		return nil, ErrEmptyLine
+	}
+
+	rule := &ScriptletRule{rawText: ruleText}
+	start, end := -1, -1
+	prefix := ""
+	for _, syntax := range scriptletSyntaxes {
+		if index := strings.Index(ruleText, syntax.separator); index >= 0 && (start < 0 || index < start) {
+			start, end = index, index+len(syntax.separator)
+			prefix = syntax.prefix
+			rule.isException = syntax.isException
+			rule.isAdGuard = syntax.isAdGuard
+		}
+	}
+	if start < 0 {
+		return nil, &ParseError{Rule: ruleText, Err: ErrUnsupportedRule}
+	}
+
+	body := strings.TrimSpace(ruleText[end:])
+	if !strings.HasPrefix(body, prefix) || !strings.HasSuffix(body, ")") {
+		return nil, &ParseError{Rule: ruleText, Err: ErrUnsupportedRule}
+	}
+	args, ok := parseScriptletArgs(body[len(prefix) : len(body)-1])
+	if !ok {
+		return nil, &ParseError{Rule: ruleText, Err: ErrUnsupportedRule}
+	}
+	if len(args) > 0 {
+		rule.name, rule.args = args[0], args[1:]
+	}
+	if !rule.isAdGuard {
+		rule.name = strings.TrimSuffix(rule.name, ".js")
+	}
+	// Only exceptions can leave out the name, to disable all the scriptlets
+	if rule.name == "" && (!rule.isException || len(rule.args) > 0) {
+		return nil, &ParseError{Rule: ruleText, Err: ErrUnsupportedRule}
+	}
+
+	rule.domains = parseDomains(ruleText[:start])
+	return rule, nil
+}
+
+// parseScriptletArgs splits the arguments on commas. Arguments may be quoted,
+// commas and the quotes inside quoted arguments are escaped with a backslash.
+func parseScriptletArgs(text string) ([]string, bool) {
+	if strings.TrimSpace(text) == "" {
+		return nil, true
+	}
+
+	var args []string
+	var arg strings.Builder
+	var quote byte
+	quoted := false
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case c == '\\' && i+1 < len(text) && (text[i+1] == ',' || quote != 0 && (text[i+1] == quote || text[i+1] == '\\')):
+			i++
+			arg.WriteByte(text[i])
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else {
+				arg.WriteByte(c)
+			}
+		case (c == '\'' || c == '"') && strings.TrimSpace(arg.String()) == "" && !quoted:
+			quote = c
+			quoted = true
+			arg.Reset()
+		case c == ',':
+			args = append(args, scriptletArg(arg.String(), quoted))
+			arg.Reset()
+			quoted = false
+		case quoted:
+			// Only spaces may follow a closing quote
+			if c != ' ' && c != '\t' {
+				return nil, false
+			}
+		default:
+			arg.WriteByte(c)
+		}
+	}
+	if quote != 0 {
+		return nil, false
+	}
+	return append(args, scriptletArg(arg.String(), quoted)), true
+}
+
+func scriptletArg(arg string, quoted bool) string {
+	if quoted {
+		return arg
+	}
+	return strings.TrimSpace(arg)
+}
+
+// Name returns the name of the scriptlet to run, empty for exceptions
+// disabling all the scriptlets
+func (rule *ScriptletRule) Name() string {
+	return rule.name
+}
+
+// Args returns the arguments given to the scriptlet
+func (rule *ScriptletRule) Args() []string {
+	return append([]string(nil), rule.args...)
+}
+
+// IsException tells if the rule stops running the scriptlet on its domains
+func (rule *ScriptletRule) IsException() bool {
+	return rule.isException
+}
+
+// IsGeneric tells if the rule is not restricted to some domains
+func (rule *ScriptletRule) IsGeneric() bool {
+	return len(rule.IncludedDomains()) == 0
+}
+
+// IncludedDomains returns the sorted domains the rule is restricted to
+func (rule *ScriptletRule) IncludedDomains() []string {
+	return domainList(rule.domains, true)
+}
+
+// ExcludedDomains returns the sorted domains the rule doesn't apply to
+func (rule *ScriptletRule) ExcludedDomains() []string {
+	return domainList(rule.domains, false)
+}
+
+// Text returns the rule as it was written in the list
+func (rule *ScriptletRule) Text() string {
+	return rule.rawText
+}
+
+// Source returns the name of the list the rule was loaded from, if any
+func (rule *ScriptletRule) Source() string {
+	return rule.source
+}
+
+// String returns the rule in canonical filter syntax, domains sorted by name,
+// keeping the syntax it was written in
+func (rule *ScriptletRule) String() string {
+	for _, syntax := range scriptletSyntaxes {
+		if syntax.isException != rule.isException || syntax.isAdGuard != rule.isAdGuard {
+			continue
+		}
+		return domainsText(rule.domains) + syntax.separator + syntax.prefix + rule.argsText() + ")"
+	}
+	return rule.rawText
+}
+
+func (rule *ScriptletRule) argsText() string {
+	if rule.name == "" {
+		return ""
+	}
+	args := make([]string, 0, len(rule.args)+1)
+	for _, arg := range append([]string{rule.name}, rule.args...) {
+		if rule.isAdGuard {
+			args = append(args, "'"+strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(arg)+"'")
+		} else {
+			args = append(args, strings.Replace(arg, ",", `\,`, -1))
+		}
+	}
+	return strings.Join(args, ", ")
+}
+
+// call identifies the scriptlet run by the rule, whatever its domains. The
+// libraries of uBlock Origin and AdGuard differ, AdGuard calls are marked and
+// sorted after the same uBlock Origin ones.
+func (rule *ScriptletRule) call() string {
+	call := rule.name + "\x00" + strings.Join(rule.args, "\x00")
+	if rule.isAdGuard {
+		call += "\x01"
+	}
+	return call
+}
+
+// excepts tells if the exception disables the scriptlet of the rule, written
+// in the same syntax. An exception without arguments disables all the calls of
+// its scriptlet.
+func (rule *ScriptletRule) excepts(scriptlet *ScriptletRule) bool {
+	if rule.isAdGuard != scriptlet.isAdGuard {
+		return false
+	}
+	if rule.name == "" {
+		return true
+	}
+	if rule.name != scriptlet.name {
+		return false
+	}
+	return len(rule.args) == 0 || rule.call() == scriptlet.call()
+}
+
+func (rule *ScriptletRule) appliesTo(hostname string) bool {
+	return len(rule.domains) == 0 || matchDocumentDomains(rule.domains, hostname)
+}
+
+// AddScriptletRule Adds a scriptlet rule to the set
+func (set *CosmeticSet) AddScriptletRule(rule *ScriptletRule) {
+	switch {
+	case rule.isException:
+		set.scriptletExceptions = append(set.scriptletExceptions, rule)
+	case rule.IsGeneric():
+		set.genericScriptlets = append(set.genericScriptlets, rule)
+	default:
+		for _, domain := range rule.IncludedDomains() {
+			set.specificScriptlets[domain] = append(set.specificScriptlets[domain], rule)
+		}
+	}
+}
+
+// Scriptlets returns the scriptlets to run on the page, one rule for each
+// call, sorted by name then arguments
+func (set *CosmeticSet) Scriptlets(pageURL *url.URL) []*ScriptletRule {
+	hostname := normalizeHostname(pageURL.Hostname())
+	var exceptions []*ScriptletRule
+	for _, exception := range set.scriptletExceptions {
+		if exception.appliesTo(hostname) {
+			exceptions = append(exceptions, exception)
+		}
+	}
+
+	found := map[string]*ScriptletRule{}
+	add := func(rule *ScriptletRule) {
+		if _, ok := found[rule.call()]; ok || !rule.appliesTo(hostname) {
+			return
+		}
+		for _, exception := range exceptions {
+			if exception.excepts(rule) {
+				return
+			}
+		}
+		found[rule.call()] = rule
+	}
+	for _, rule := range set.genericScriptlets {
+		add(rule)
+	}
+	for _, domain := range hostnameDomains(hostname) {
+		for _, rule := range set.specificScriptlets[domain] {
+			add(rule)
+		}
+	}
+
+	rules := make([]*ScriptletRule, 0, len(found))
+	for _, rule := range found {
+		rules = append(rules, rule)
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		return rules[i].call() < rules[j].call()
+	})
+	return rules
+}
@@ -0,0 +1,159 @@
+package adblockgoparser
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newScriptletSetFromList(t *testing.T, rulesStr []string) *CosmeticSet {
+	set := CreateCosmeticSet()
+	for _, ruleStr := range rulesStr {
+		rule, err := ParseScriptletRule(ruleStr)
+		assert.NoError(t, err, ruleStr)
+		set.AddScriptletRule(rule)
+	}
+	return set
+}
+
+func scriptletTexts(set *CosmeticSet, rawURL string) []string {
+	pageURL, _ := url.Parse(rawURL)
+	var texts []string
+	for _, rule := range set.Scriptlets(pageURL) {
+		texts = append(texts, rule.String())
+	}
+	return texts
+}
+
+func TestParsingScriptletRule(t *testing.T) {
+	rule, err := ParseScriptletRule("example.com,~sub.example.com##+js(set-constant.js, ads.enabled, false)")
+	assert.NoError(t, err)
+	assert.Equal(t, "set-constant", rule.Name())
+	assert.Equal(t, []string{"ads.enabled", "false"}, rule.Args())
+	assert.False(t, rule.IsException())
+	assert.Equal(t, []string{"example.com"}, rule.IncludedDomains())
+	assert.Equal(t, []string{"sub.example.com"}, rule.ExcludedDomains())
+	assert.Equal(t, "example.com,~sub.example.com##+js(set-constant, ads.enabled, false)", rule.String())
+
+	rule, err = ParseScriptletRule(`##+js(abort-on-property-read, /a\,b\d/, "x, y")`)
+	assert.NoError(t, err)
+	assert.True(t, rule.IsGeneric())
+	assert.Equal(t, []string{`/a,b\d/`, "x, y"}, rule.Args())
+	assert.Equal(t, `##+js(abort-on-property-read, /a\,b\d/, x\, y)`, rule.String())
+
+	rule, err = ParseScriptletRule(`example.org#%#//scriptlet('set-constant', 'it\'s', "1")`)
+	assert.NoError(t, err)
+	assert.Equal(t, "set-constant", rule.Name())
+	assert.Equal(t, []string{"it's", "1"}, rule.Args())
+	assert.Equal(t, `example.org#%#//scriptlet('set-constant', 'it\'s', '1')`, rule.String())
+
+	rule, err = ParseScriptletRule("example.org#@%#//scriptlet('set-constant')")
+	assert.NoError(t, err)
+	assert.True(t, rule.IsException())
+	assert.Empty(t, rule.Args())
+
+	rule, err = ParseScriptletRule("example.org#@#+js()")
+	assert.NoError(t, err)
+	assert.True(t, rule.IsException())
+	assert.Equal(t, "", rule.Name())
+	assert.Equal(t, "example.org#@#+js()", rule.String())
+
+	for _, ruleText := range []string{
+		"##+js()",
+		"example.com##+js(noeval",
+		"example.com#%#window.ads = false",
+		"example.com#%#//scriptlet('noeval)",
+		"example.com##.ad",
+	} {
+		_, err := ParseScriptletRule(ruleText)
+		assert.True(t, errors.Is(err, ErrUnsupportedRule), ruleText)
+	}
+}
+
+func TestScriptletRuleSkippedByParseRule(t *testing.T) {
+	for _, ruleText := range []string{
+		"example.com##+js(noeval)",
+		"example.com#%#//scriptlet('noeval')",
+		"example.com#@%#//scriptlet('noeval')",
+	} {
+		_, err := ParseRule(ruleText)
+		assert.Equal(t, ErrSkipHTML, err, ruleText)
+	}
+}
+
+func TestScriptlets(t *testing.T) {
+	set := newScriptletSetFromList(t, []string{
+		"##+js(noeval)",
+		"example.com##+js(set-constant, ads, false)",
+		"example.com#%#//scriptlet('set-constant', 'ads', 'false')",
+		"example.com##+js(set-constant, tracker, undefined)",
+		"example.com,~news.example.com##+js(nowebrtc)",
+		"shop.example.com#@#+js(set-constant, tracker, undefined)",
+		"example.*##+js(nano-setInterval-booster)",
+		"blog.example.com#@#+js(set-constant)",
+		"other.com#@#+js()",
+		"news.example.com#@%#//scriptlet('set-constant')",
+	})
+
+	assert.Equal(t, []string{
+		"example.*##+js(nano-setInterval-booster)",
+		"##+js(noeval)",
+		"example.com,~news.example.com##+js(nowebrtc)",
+		"example.com##+js(set-constant, ads, false)",
+		"example.com#%#//scriptlet('set-constant', 'ads', 'false')",
+		"example.com##+js(set-constant, tracker, undefined)",
+	}, scriptletTexts(set, "https://www.example.com/page"))
+
+	assert.Equal(t, []string{
+		"example.*##+js(nano-setInterval-booster)",
+		"##+js(noeval)",
+		"example.com,~news.example.com##+js(nowebrtc)",
+		"example.com##+js(set-constant, ads, false)",
+		"example.com#%#//scriptlet('set-constant', 'ads', 'false')",
+	}, scriptletTexts(set, "https://shop.example.com/"))
+
+	// Exceptions only disable the scriptlets written in the same syntax
+	assert.Equal(t, []string{
+		"example.*##+js(nano-setInterval-booster)",
+		"##+js(noeval)",
+		"example.com,~news.example.com##+js(nowebrtc)",
+		"example.com#%#//scriptlet('set-constant', 'ads', 'false')",
+	}, scriptletTexts(set, "https://blog.example.com/"))
+
+	assert.Equal(t, []string{
+		"example.*##+js(nano-setInterval-booster)",
+		"##+js(noeval)",
+		"example.com##+js(set-constant, ads, false)",
+		"example.com##+js(set-constant, tracker, undefined)",
+	}, scriptletTexts(set, "https://news.example.com/"))
+
+	assert.Equal(t, []string{"example.*##+js(nano-setInterval-booster)", "##+js(noeval)"}, scriptletTexts(set, "https://example.org/"))
+	assert.Equal(t, []string{"##+js(noeval)"}, scriptletTexts(set, "https://example.net.invalid/"))
+	assert.Empty(t, scriptletTexts(set, "https://other.com/"))
+}
+
+func TestLoadScriptletRules(t *testing.T) {
+	list := strings.Join([]string{
+		"! Title: Scriptlets",
+		"example.com##+js(noeval)",
+		"example.com#%#//scriptlet('abort-on-property-read', 'ads')",
+		"example.com##+js(noeval",
+	}, "\n")
+
+	set := CreateCosmeticSet()
+	_, report, err := LoadRuleSet(strings.NewReader(list), WithCosmeticSet(set))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, report.ScriptletRules)
+	assert.Len(t, report.Errors, 1)
+	assert.Equal(t, 4, report.Errors[0].Line)
+
+	pageURL, _ := url.Parse("https://example.com/")
+	scriptlets := set.Scriptlets(pageURL)
+	assert.Len(t, scriptlets, 2)
+	assert.Equal(t, "abort-on-property-read", scriptlets[0].Name())
+	assert.Equal(t, "Scriptlets", scriptlets[0].Source())
+	assert.Equal(t, "noeval", scriptlets[1].Name())
+}
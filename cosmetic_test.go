@@ -0,0 +1,103 @@
+package adblockgoparser
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newCosmeticSetFromList(t *testing.T, rulesStr []string) *CosmeticSet {
+	set := CreateCosmeticSet()
+	for _, ruleStr := range rulesStr {
+		rule, err := ParseCosmeticRule(ruleStr)
+		assert.NoError(t, err, ruleStr)
+		set.AddRule(rule)
+	}
+	return set
+}
+
+func TestParsingCosmeticRule(t *testing.T) {
+	rule, err := ParseCosmeticRule("##.ad-banner")
+	assert.NoError(t, err)
+	assert.Equal(t, ".ad-banner", rule.Selector())
+	assert.False(t, rule.IsException())
+	assert.True(t, rule.IsGeneric())
+	assert.Equal(t, "##.ad-banner", rule.String())
+
+	rule, err = ParseCosmeticRule("example.com,~news.example.com,Bücher.de##div[id^=\"ad\"] > a")
+	assert.NoError(t, err)
+	assert.Equal(t, `div[id^="ad"] > a`, rule.Selector())
+	assert.False(t, rule.IsGeneric())
+	assert.Equal(t, []string{"example.com", "xn--bcher-kva.de"}, rule.IncludedDomains())
+	assert.Equal(t, []string{"news.example.com"}, rule.ExcludedDomains())
+	assert.Equal(t, `example.com,~news.example.com,xn--bcher-kva.de##div[id^="ad"] > a`, rule.String())
+
+	rule, err = ParseCosmeticRule("~example.com##.ad")
+	assert.NoError(t, err)
+	assert.True(t, rule.IsGeneric())
+
+	rule, err = ParseCosmeticRule("statejournal.com#@##WNAd41")
+	assert.NoError(t, err)
+	assert.True(t, rule.IsException())
+	assert.Equal(t, "#WNAd41", rule.Selector())
+	assert.Equal(t, "statejournal.com#@##WNAd41", rule.String())
+
+	for _, ruleText := range []string{"example.com##", "example.com##+js(noeval)", "example.com##^script", "||ads.com^"} {
+		_, err = ParseCosmeticRule(ruleText)
+		assert.True(t, errors.Is(err, ErrUnsupportedRule), ruleText)
+	}
+}
+
+func TestCosmeticSetSelectors(t *testing.T) {
+	set := newCosmeticSetFromList(t, []string{
+		"##.ad-banner",
+		"##.sponsored",
+		"~example.com##.generic-ad",
+		"example.com##.example-ad",
+		"news.example.com##.news-ad",
+		"google.*##.google-ad",
+		"example.com#@#.sponsored",
+		"www.example.com#@#.example-ad",
+	})
+
+	assert.Equal(t, []string{".ad-banner", ".generic-ad", ".sponsored"}, set.Selectors("other.com"))
+	assert.Equal(t, []string{".ad-banner", ".example-ad"}, set.Selectors("example.com"))
+	assert.Equal(t, []string{".ad-banner", ".example-ad", ".news-ad"}, set.Selectors("news.example.com"))
+	assert.Equal(t, []string{".ad-banner"}, set.Selectors("www.example.com"))
+	assert.Equal(t, []string{".ad-banner", ".generic-ad", ".sponsored"}, set.Selectors("notexample.com"))
+	assert.Equal(t, []string{".ad-banner", ".generic-ad", ".google-ad", ".sponsored"}, set.Selectors("www.google.co.uk"))
+	assert.Equal(t, []string{".example-ad", ".news-ad"}, set.SpecificSelectors("news.example.com."))
+}
+
+func TestCosmeticGenericException(t *testing.T) {
+	set := newCosmeticSetFromList(t, []string{"##.ad", "example.com##.ad", "#@#.ad"})
+	assert.Empty(t, set.Selectors("example.com"))
+	assert.Empty(t, set.Selectors("other.com"))
+}
+
+func TestLoadRuleSetWithCosmeticSet(t *testing.T) {
+	list := strings.Join([]string{
+		"! Title: Test list",
+		"||ads.example.com^",
+		"##.ad-banner",
+		"example.com#@#.ad-banner",
+		"example.com##^script:has-text(ads)",
+		"example.com##+js(noeval)",
+	}, "\n")
+
+	set := CreateCosmeticSet()
+	_, report, err := LoadRuleSet(strings.NewReader(list), WithCosmeticSet(set))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, report.Rules)
+	assert.Equal(t, 2, report.CosmeticRules)
+	assert.Equal(t, 1, report.ScriptletRules)
+	assert.Equal(t, 1, report.Skipped[ErrUnsupportedRule])
+	assert.Len(t, report.Errors, 1)
+	assert.Equal(t, 5, report.Errors[0].Line)
+
+	assert.Equal(t, []string{".ad-banner"}, set.Selectors("other.com"))
+	assert.Empty(t, set.Selectors("example.com"))
+	assert.Equal(t, "Test list", set.generic[0].Source())
+}
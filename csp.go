@@ -0,0 +1,41 @@
+package adblockgoparser
+
+import "sort"
+
+// CSP returns the Content-Security-Policy header values to add to the
+// response of a document request, given by $csp rules. Each value is a policy
+// of its own, they can be sent as separate headers or joined with commas.
+// Exceptions with $csp disable the rules with the same policy, or all of them
+// when they have none. Requests of unknown type are taken as documents when
+// they have no Referer nor Origin, like a page opened from the address bar.
+func (ruleSet *RuleSet) CSP(req *Request) []string {
+	req = normalizeRequest(req)
+	switch requestType(req) {
+	case ResourceDocument, ResourceSubdocument:
+	case ResourceUnknown:
+		if req.Referer != "" || req.Origin != "" {
+			return nil
+		}
+	default:
+		return nil
+	}
+	page := normalizeRequest(&Request{URL: documentURL(req), ResourceType: ResourceDocument})
+	if ruleSet.pageWhite["document"].Match(page) != nil {
+		return nil
+	}
+
+	exceptions := ruleSet.cspWhite.MatchAll(req)
+	policies := map[string]struct{}{}
+	for _, rule := range ruleSet.cspBlack.MatchAll(req) {
+		if !isOptionExcepted(exceptions, rule, "csp") {
+			policies[rule.values["csp"]] = struct{}{}
+		}
+	}
+
+	var values []string
+	for policy := range policies {
+		values = append(values, policy)
+	}
+	sort.Strings(values)
+	return values
+}
@@ -0,0 +1,68 @@
+package adblockgoparser
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsingCSP(t *testing.T) {
+	rule, err := ParseRule("||example.com^$csp=script-src 'self' 'unsafe-inline'; worker-src 'none'")
+	assert.NoError(t, err)
+	value, _ := rule.OptionValue("csp")
+	assert.Equal(t, "script-src 'self' 'unsafe-inline'; worker-src 'none'", value)
+	assert.Equal(t, "||example.com^$csp=script-src 'self' 'unsafe-inline'; worker-src 'none'", rule.String())
+
+	_, err = ParseRule("@@||example.com^$csp")
+	assert.NoError(t, err)
+
+	for _, ruleText := range []string{
+		"||example.com^$csp",
+		"||example.com^$csp=default-src 'self'; report-uri https://report.example/",
+		"||example.com^$~csp=worker-src 'none'",
+	} {
+		_, err = ParseRule(ruleText)
+		assert.True(t, errors.Is(err, ErrUnsupportedRule), ruleText)
+	}
+}
+
+func TestCSP(t *testing.T) {
+	rules := []string{
+		"$csp=worker-src 'none',domain=example.com|news.com",
+		"||example.com^$csp=script-src 'self'",
+		"||example.com^$csp=script-src 'self'",
+		"@@||www.news.com^$csp=worker-src 'none'",
+		"@@||shop.example.com^$csp",
+		"@@||partner.com^$document",
+		"$csp=img-src 'none',domain=partner.com",
+	}
+	ruleSet, err := newRuleSetFromList(rules)
+	assert.NoError(t, err)
+
+	req := reqFromURL("http://www.example.com/")
+	req.ResourceType = ResourceDocument
+	assert.Equal(t, []string{"script-src 'self'", "worker-src 'none'"}, ruleSet.CSP(req))
+
+	// Exceptions disable the same policy, or all of them
+	req = reqFromURL("http://news.com/")
+	assert.Equal(t, []string{"worker-src 'none'"}, ruleSet.CSP(req))
+	req = reqFromURL("http://www.news.com/")
+	assert.Empty(t, ruleSet.CSP(req))
+	req = reqFromURL("http://shop.example.com/")
+	assert.Empty(t, ruleSet.CSP(req))
+	req = reqFromURL("http://partner.com/")
+	assert.Empty(t, ruleSet.CSP(req))
+
+	// Only documents get policies
+	req = reqFromURL("http://www.example.com/app.js")
+	assert.Empty(t, ruleSet.CSP(req))
+	req = reqFromURL("http://www.example.com/api")
+	req.Referer = "http://www.example.com/"
+	assert.Empty(t, ruleSet.CSP(req))
+	req.ResourceType = ResourceSubdocument
+	assert.Equal(t, []string{"script-src 'self'", "worker-src 'none'"}, ruleSet.CSP(req))
+
+	// Rules with $csp don't block
+	assert.True(t, ruleSet.Allow(reqFromURL("http://www.example.com/")))
+}
@@ -2,4 +2,10 @@ module github.com/scrapinghub/adblockgoparser
 
 go 1.13
 
-require github.com/stretchr/testify v1.5.1
+require (
+	github.com/andybalholm/cascadia v1.3.2
+	github.com/antchfx/htmlquery v1.3.0
+	github.com/antchfx/xpath v1.2.4
+	github.com/stretchr/testify v1.5.1
+	golang.org/x/net v0.11.0
+)
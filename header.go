@@ -0,0 +1,103 @@
+package adblockgoparser
+
+import (
+	"net/http"
+	"net/textproto"
+	"regexp"
+	"strings"
+)
+
+// headerCondition is the value of a $header option: a response header which
+// must be present, optionally with a value or a value matching a regex.
+// A ~ before the value inverts it.
+type headerCondition struct {
+	name    string
+	value   string
+	regex   *regexp.Regexp
+	negated bool
+}
+
+// parseHeaderCondition parses the value of $header, like via:1.1 google or
+// x-powered-by:/^php/i
+func parseHeaderCondition(text string) (*headerCondition, error) {
+	header := &headerCondition{name: text}
+	if index := strings.Index(text, ":"); index >= 0 {
+		header.name, header.value = text[:index], text[index+1:]
+		if strings.HasPrefix(header.value, "~") {
+			header.negated = true
+			header.value = header.value[1:]
+		}
+		if header.value == "" {
+			return nil, ErrUnsupportedRule
+		}
+		if pattern, flags, ok := regexLiteral(header.value); ok {
+			re, err := regexp.Compile(flags + pattern)
+			if err != nil {
+				return nil, &causeError{reason: ErrBadRegex, cause: err}
+			}
+			header.regex = re
+		}
+	}
+	header.name = textproto.CanonicalMIMEHeaderKey(strings.TrimSpace(header.name))
+	if header.name == "" {
+		return nil, ErrUnsupportedRule
+	}
+	return header, nil
+}
+
+// match tells if the response headers fulfill the condition
+func (header *headerCondition) match(responseHeader http.Header) bool {
+	values, ok := responseHeader[header.name]
+	if !ok {
+		return false
+	}
+	if header.value == "" {
+		return true
+	}
+	for _, value := range values {
+		var matched bool
+		if header.regex != nil {
+			matched = header.regex.MatchString(value)
+		} else {
+			matched = strings.EqualFold(strings.TrimSpace(value), header.value)
+		}
+		if matched != header.negated {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckResponse matches the response of a request against the rules with
+// $header, which need its headers in Request.ResponseHeader. It complements
+// Check, which can't know the response. Exceptions with $header disable the
+// rules with the same value, or all of them when they have none, and the
+// exceptions allowing the request also allow its response.
+func (ruleSet *RuleSet) CheckResponse(req *Request) Result {
+	req = normalizeRequest(req)
+	result := Result{Allowed: true}
+	if req.ResponseHeader == nil {
+		return result
+	}
+
+	exceptions := ruleSet.headerWhite.MatchAll(req)
+	for _, rule := range ruleSet.headerBlack.MatchAll(req) {
+		if !isOptionExcepted(exceptions, rule, "header") {
+			result.Rule = rule
+			break
+		}
+	}
+	if result.Rule == nil {
+		return result
+	}
+
+	page := normalizeRequest(&Request{URL: documentURL(req), ResourceType: ResourceDocument})
+	result.Exception = ruleSet.matchException(req, page)
+	result.Allowed = result.Exception != nil
+	return result
+}
+
+// AllowResponse tells if the response of the request can be used
+func (ruleSet *RuleSet) AllowResponse(req *Request) bool {
+	return ruleSet.CheckResponse(req).Allowed
+}
@@ -0,0 +1,86 @@
+package adblockgoparser
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func reqWithResponse(rawURL string, header http.Header) *Request {
+	req := reqFromURL(rawURL)
+	req.ResponseHeader = header
+	return req
+}
+
+func TestParsingHeader(t *testing.T) {
+	rule, err := ParseRule("||example.com^$header=via:1.1 google")
+	assert.NoError(t, err)
+	value, _ := rule.OptionValue("header")
+	assert.Equal(t, "via:1.1 google", value)
+	assert.Equal(t, "||example.com^$header=via:1.1 google", rule.String())
+
+	_, err = ParseRule("*$script,header=x-powered-by:/^php\\/[57]/i")
+	assert.NoError(t, err)
+	_, err = ParseRule("@@||example.com^$header")
+	assert.NoError(t, err)
+
+	for _, ruleText := range []string{
+		"||example.com^$header",
+		"||example.com^$header=via:",
+		"||example.com^$header=:value",
+	} {
+		_, err = ParseRule(ruleText)
+		assert.True(t, errors.Is(err, ErrUnsupportedRule), ruleText)
+	}
+	_, err = ParseRule("||example.com^$header=via:/(/")
+	assert.True(t, errors.Is(err, ErrBadRegex))
+}
+
+func TestCheckResponse(t *testing.T) {
+	rules := []string{
+		"||cdn.com^$header=via:1.1 tracker",
+		"||ads.com^$header=x-ad-server",
+		"/api/*$header=server:/^nginx\\/1\\.1\\d/i",
+		"||shop.com^$header=cache-control:~no-store",
+		"@@/api/public/*$header=server:/^nginx\\/1\\.1\\d/i",
+		"@@||partner.com^$document",
+		"||blocked.com^",
+	}
+	ruleSet, err := newRuleSetFromList(rules)
+	assert.NoError(t, err)
+
+	// Header rules don't block requests
+	assert.True(t, ruleSet.Allow(reqFromURL("http://cdn.com/lib.js")))
+	assert.True(t, ruleSet.AllowResponse(reqFromURL("http://cdn.com/lib.js")))
+
+	result := ruleSet.CheckResponse(reqWithResponse("http://cdn.com/lib.js", http.Header{"Via": {"1.1 Tracker"}}))
+	assert.False(t, result.Allowed)
+	assert.Equal(t, "||cdn.com^$header=via:1.1 tracker", result.Rule.String())
+	assert.True(t, ruleSet.AllowResponse(reqWithResponse("http://cdn.com/lib.js", http.Header{"Via": {"1.1 varnish"}})))
+
+	// Headers only need to be present when the rule has no value
+	assert.False(t, ruleSet.AllowResponse(reqWithResponse("http://ads.com/", http.Header{"X-Ad-Server": {""}})))
+	assert.True(t, ruleSet.AllowResponse(reqWithResponse("http://ads.com/", http.Header{})))
+
+	// Regex and negated values
+	assert.False(t, ruleSet.AllowResponse(reqWithResponse("http://example.com/api/v1", http.Header{"Server": {"NGINX/1.14.0"}})))
+	assert.True(t, ruleSet.AllowResponse(reqWithResponse("http://example.com/api/v1", http.Header{"Server": {"nginx/1.20.1"}})))
+	assert.False(t, ruleSet.AllowResponse(reqWithResponse("http://shop.com/", http.Header{"Cache-Control": {"max-age=60"}})))
+	assert.True(t, ruleSet.AllowResponse(reqWithResponse("http://shop.com/", http.Header{"Cache-Control": {"no-store"}})))
+
+	// Exceptions
+	result = ruleSet.CheckResponse(reqWithResponse("http://example.com/api/public/v1", http.Header{"Server": {"nginx/1.14.0"}}))
+	assert.True(t, result.Allowed)
+	assert.Nil(t, result.Rule)
+
+	req := reqWithResponse("http://cdn.com/lib.js", http.Header{"Via": {"1.1 tracker"}})
+	req.Referer = "http://partner.com/"
+	result = ruleSet.CheckResponse(req)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, "@@||partner.com^$document", result.Exception.String())
+
+	// Request rules are left to Check
+	assert.True(t, ruleSet.AllowResponse(reqWithResponse("http://blocked.com/", http.Header{})))
+}
@@ -0,0 +1,334 @@
+package adblockgoparser
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseErrors collects the rules of a list which could not be parsed
+type ParseErrors []*ParseError
+
+func (errs ParseErrors) Error() string {
+	switch len(errs) {
+	case 0:
+		return "no parse errors"
+	case 1:
+		return errs[0].Error()
+	default:
+		return fmt.Sprintf("%s (and %d more errors)", errs[0], len(errs)-1)
+	}
+}
+
+// LoadOption configures how a filter list is loaded
+type LoadOption func(*loadConfig)
+
+type loadConfig struct {
+	failOn      []error
+	source      string
+	cosmeticSet *CosmeticSet
+}
+
+// WithCosmeticSet adds the element hiding and scriptlet rules of the list to
+// the set, instead of skipping them
+func WithCosmeticSet(set *CosmeticSet) LoadOption {
+	return func(config *loadConfig) {
+		config.cosmeticSet = set
+	}
+}
+
+// WithSource names the list the rules come from, instead of its title
+func WithSource(name string) LoadOption {
+	return func(config *loadConfig) {
+		config.source = name
+	}
+}
+
+// FailOn makes the load fail on the first line skipped for one of the given
+// reasons, like ErrUnsupportedRule or ErrSkipHTML, instead of counting it
+func FailOn(reasons ...error) LoadOption {
+	return func(config *loadConfig) {
+		config.failOn = append(config.failOn, reasons...)
+	}
+}
+
+func (config *loadConfig) fails(err error) bool {
+	for _, reason := range config.failOn {
+		if errors.Is(err, reason) {
+			return true
+		}
+	}
+	return false
+}
+
+// ListMetadata holds the header of a filter list
+type ListMetadata struct {
+	// Format from the first line, like "Adblock Plus 2.0"
+	Format string
+	// Title of the list
+	Title string
+	// Version of the list
+	Version string
+	// Expires tells how often the list should be refreshed
+	Expires time.Duration
+	// Homepage of the list
+	Homepage string
+	// License of the list
+	License string
+	// LastModified date of the list, zero if missing or unknown format
+	LastModified time.Time
+}
+
+var (
+	headerFormatPat  = regexp.MustCompile(`^\[(.+)\]$`)
+	headerFieldPat   = regexp.MustCompile(`^!\s*([\w ]+?)\s*:\s*(.*?)\s*$`)
+	headerExpiresPat = regexp.MustCompile(`(?i)^(\d+)\s*(day|hour)s?\b`)
+	// Layouts of the "Last modified" field found in the wild
+	lastModifiedLayouts = []string{
+		"02 Jan 2006 15:04 MST",
+		"2 Jan 2006 15:04 MST",
+		time.RFC1123,
+		time.RFC1123Z,
+		time.RFC3339,
+		"2006-01-02T15:04:05.000Z",
+		"2006-01-02 15:04:05",
+		"2006-01-02",
+	}
+)
+
+// parseHeader fills the metadata from a header line. It returns false once
+// the line is not part of the header.
+func (metadata *ListMetadata) parseHeader(line string) bool {
+	line = strings.TrimSpace(line)
+	if match := headerFormatPat.FindStringSubmatch(line); match != nil {
+		metadata.Format = match[1]
+		return true
+	}
+	if !strings.HasPrefix(line, "!") {
+		return false
+	}
+
+	match := headerFieldPat.FindStringSubmatch(line)
+	if match == nil {
+		return true
+	}
+	value := match[2]
+	switch strings.ToLower(match[1]) {
+	case "title":
+		metadata.Title = value
+	case "version":
+		metadata.Version = value
+	case "expires":
+		if expires := headerExpiresPat.FindStringSubmatch(value); expires != nil {
+			count, _ := strconv.Atoi(expires[1])
+			unit := time.Hour
+			if strings.EqualFold(expires[2], "day") {
+				unit = 24 * time.Hour
+			}
+			metadata.Expires = time.Duration(count) * unit
+		}
+	case "homepage":
+		metadata.Homepage = value
+	case "license", "licence":
+		metadata.License = value
+	case "last modified", "last updated", "updated":
+		for _, layout := range lastModifiedLayouts {
+			if date, err := time.Parse(layout, value); err == nil {
+				metadata.LastModified = date
+				break
+			}
+		}
+	}
+	return true
+}
+
+// LoadReport summarizes the load of a filter list
+type LoadReport struct {
+	// Metadata from the header of the list
+	Metadata ListMetadata
+	// Lines read from the list
+	Lines int
+	// Rules added to the RuleSet
+	Rules int
+	// CosmeticRules added to the CosmeticSet
+	CosmeticRules int
+	// ScriptletRules added to the CosmeticSet
+	ScriptletRules int
+	// Skipped lines by reason, like ErrSkipComment or ErrUnsupportedRule
+	Skipped map[error]int
+	// Errors rules which could not be parsed
+	Errors ParseErrors
+}
+
+// skipReasons are the errors used as keys of LoadReport.Skipped
+var skipReasons = []error{ErrEmptyLine, ErrSkipComment, ErrSkipHTML, ErrUnsupportedRule, ErrBadRegex, ErrBadSelector}
+
+func (report *LoadReport) skip(err error) {
+	for _, reason := range skipReasons {
+		if errors.Is(err, reason) {
+			report.Skipped[reason]++
+			return
+		}
+	}
+}
+
+// parseList calls fn with each line of the list and the result of parsing it.
+// Parse errors get the line number set. Lines are read whole, whatever their
+// length.
+func parseList(r io.Reader, fn func(text string, rule *RuleAdBlock, err error) error) error {
+	reader := bufio.NewReader(r)
+	line := 0
+	for {
+		text, readErr := reader.ReadString('\n')
+		if readErr != nil && (readErr != io.EOF || text == "") {
+			if readErr == io.EOF {
+				return nil
+			}
+			return readErr
+		}
+		line++
+		text = strings.TrimSuffix(strings.TrimSuffix(text, "\n"), "\r")
+		rule, err := ParseRule(text)
+		var parseErr *ParseError
+		if errors.As(err, &parseErr) {
+			parseErr.Line = line
+		}
+		if err := fn(text, rule, err); err != nil {
+			return err
+		}
+	}
+}
+
+// ParseRules parses every line of a filter list. Comments, HTML rules and
+// empty lines are skipped. Rules which cannot be parsed don't stop the
+// parsing, they are returned as ParseErrors along with the parsed rules.
+func ParseRules(r io.Reader) ([]*RuleAdBlock, error) {
+	var rules []*RuleAdBlock
+	var parseErrs ParseErrors
+
+	err := parseList(r, func(text string, rule *RuleAdBlock, err error) error {
+		var parseErr *ParseError
+		switch {
+		case err == nil:
+			rules = append(rules, rule)
+		case errors.As(err, &parseErr):
+			parseErrs = append(parseErrs, parseErr)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(parseErrs) > 0 {
+		return rules, parseErrs
+	}
+	return rules, nil
+}
+
+// LoadRuleSet creates a RuleSet from a filter list
+func LoadRuleSet(r io.Reader, opts ...LoadOption) (*RuleSet, *LoadReport, error) {
+	ruleSet := CreateRuleSet()
+	report, err := ruleSet.AddList(r, opts...)
+	if err != nil {
+		return nil, report, err
+	}
+	return ruleSet, report, nil
+}
+
+// AddList adds all the rules of a filter list. Lines which are not rules are
+// skipped and counted in the report, unless the options make them fail the
+// load. The error is only set when the list cannot be read or fails the load,
+// then none of its rules are added.
+func (ruleSet *RuleSet) AddList(r io.Reader, opts ...LoadOption) (*LoadReport, error) {
+	config := &loadConfig{}
+	for _, opt := range opts {
+		opt(config)
+	}
+
+	report := &LoadReport{Skipped: map[error]int{}}
+	var rules []*RuleAdBlock
+	var cosmeticRules []*CosmeticRule
+	var scriptletRules []*ScriptletRule
+	inHeader := true
+	err := parseList(r, func(text string, rule *RuleAdBlock, err error) error {
+		report.Lines++
+		if inHeader {
+			inHeader = report.Metadata.parseHeader(text)
+		}
+		source := config.source
+		if source == "" {
+			source = report.Metadata.Title
+		}
+		if err == nil {
+			rule.source = source
+			rules = append(rules, rule)
+			return nil
+		}
+		if errors.Is(err, ErrSkipHTML) && config.cosmeticSet != nil && isScriptletRule(text) {
+			var scriptletRule *ScriptletRule
+			if scriptletRule, err = ParseScriptletRule(text); err == nil {
+				scriptletRule.source = source
+				scriptletRules = append(scriptletRules, scriptletRule)
+				return nil
+			}
+			var parseErr *ParseError
+			if errors.As(err, &parseErr) {
+				parseErr.Line = report.Lines
+			}
+		} else if errors.Is(err, ErrSkipHTML) && config.cosmeticSet != nil {
+			var cosmeticRule *CosmeticRule
+			if cosmeticRule, err = ParseCosmeticRule(text); err == nil {
+				cosmeticRule.source = source
+				cosmeticRules = append(cosmeticRules, cosmeticRule)
+				return nil
+			}
+			var parseErr *ParseError
+			if errors.As(err, &parseErr) {
+				parseErr.Line = report.Lines
+			}
+		}
+
+		if config.fails(err) {
+			if !errors.As(err, new(*ParseError)) {
+				err = &ParseError{Line: report.Lines, Rule: text, Err: err}
+			}
+			return err
+		}
+		report.skip(err)
+		var parseErr *ParseError
+		if errors.As(err, &parseErr) {
+			report.Errors = append(report.Errors, parseErr)
+		}
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+
+	for _, rule := range rules {
+		ruleSet.AddRule(rule)
+	}
+	for _, rule := range cosmeticRules {
+		config.cosmeticSet.AddRule(rule)
+	}
+	for _, rule := range scriptletRules {
+		config.cosmeticSet.AddScriptletRule(rule)
+	}
+	report.Rules = len(rules)
+	report.CosmeticRules = len(cosmeticRules)
+	report.ScriptletRules = len(scriptletRules)
+	ruleSet.lists = append(ruleSet.lists, report.Metadata)
+	return report, nil
+}
+
+// Lists returns the metadata of the lists added to the RuleSet, in the order
+// they were added
+func (ruleSet *RuleSet) Lists() []ListMetadata {
+	return append([]ListMetadata(nil), ruleSet.lists...)
+}
@@ -0,0 +1,193 @@
+package adblockgoparser
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRules(t *testing.T) {
+	list := strings.Join([]string{
+		"[Adblock Plus 2.0]",
+		"! Title: Test list",
+		"",
+		"||ads.example.com^",
+		"##.ad-banner",
+		"||tracker.com^$badoption",
+		"@@||ads.example.com/allowed^",
+		"/ads(/",
+		"||other.com^$~otherbad,script",
+	}, "\n")
+
+	rules, err := ParseRules(strings.NewReader(list))
+	assert.Len(t, rules, 2)
+	assert.Equal(t, "||ads.example.com^", rules[0].ruleText)
+	assert.Equal(t, "||ads.example.com/allowed^", rules[1].ruleText)
+
+	var parseErrs ParseErrors
+	assert.True(t, errors.As(err, &parseErrs))
+	assert.Len(t, parseErrs, 3)
+
+	assert.Equal(t, 6, parseErrs[0].Line)
+	assert.Equal(t, "||tracker.com^$badoption", parseErrs[0].Rule)
+	assert.Equal(t, "badoption", parseErrs[0].Option)
+	assert.True(t, errors.Is(parseErrs[0], ErrUnsupportedRule))
+	assert.EqualError(t, parseErrs[0], `line 6: Unsupported option rules are skipped: option "badoption" in "||tracker.com^$badoption"`)
+
+	assert.Equal(t, 8, parseErrs[1].Line)
+	assert.False(t, errors.Is(parseErrs[1], ErrUnsupportedRule))
+
+	assert.Equal(t, 9, parseErrs[2].Line)
+	assert.Equal(t, "~otherbad", parseErrs[2].Option)
+
+	assert.EqualError(t, err, parseErrs[0].Error()+" (and 2 more errors)")
+}
+
+func TestParseRulesWithoutErrors(t *testing.T) {
+	rules, err := ParseRules(strings.NewReader("||ads.example.com^\n! comment\n"))
+	assert.NoError(t, err)
+	assert.Len(t, rules, 1)
+}
+
+func TestLoadRuleSet(t *testing.T) {
+	list := strings.Join([]string{
+		"[Adblock Plus 2.0]",
+		"! Title: Test list",
+		"",
+		"||ads.example.com^",
+		"##.ad-banner",
+		"example.com#@#.ad-banner",
+		"||tracker.com^$badoption",
+		"@@/allowed/*$domain=example.com",
+		"/ads(/",
+	}, "\n")
+
+	ruleSet, report, err := LoadRuleSet(strings.NewReader(list))
+	assert.NoError(t, err)
+	assert.Equal(t, 9, report.Lines)
+	assert.Equal(t, 2, report.Rules)
+	assert.Equal(t, map[error]int{
+		ErrSkipComment:     2,
+		ErrEmptyLine:       1,
+		ErrSkipHTML:        2,
+		ErrUnsupportedRule: 1,
+		ErrBadRegex:        1,
+	}, report.Skipped)
+	assert.Len(t, report.Errors, 2)
+	assert.Equal(t, 7, report.Errors[0].Line)
+	assert.Equal(t, 9, report.Errors[1].Line)
+
+	assert.False(t, ruleSet.Allow(reqFromURL("http://ads.example.com/banner.gif")))
+	assert.True(t, ruleSet.Allow(reqFromURL("http://ads.example.com/allowed/banner.gif")))
+	assert.True(t, ruleSet.Allow(reqFromURL("http://tracker.com/")))
+}
+
+func TestLoadRuleSetFailOn(t *testing.T) {
+	list := "||ads.example.com^\n##.ad-banner\n||tracker.com^$badoption\n"
+
+	_, report, err := LoadRuleSet(strings.NewReader(list), FailOn(ErrUnsupportedRule))
+	assert.True(t, errors.Is(err, ErrUnsupportedRule))
+	assert.EqualError(t, err, `line 3: Unsupported option rules are skipped: option "badoption" in "||tracker.com^$badoption"`)
+	assert.Equal(t, 0, report.Rules)
+
+	// The rules read before the failure are not added
+	ruleSet := CreateRuleSet()
+	_, err = ruleSet.AddList(strings.NewReader(list), FailOn(ErrUnsupportedRule))
+	assert.Error(t, err)
+	assert.True(t, ruleSet.Allow(reqFromURL("http://ads.example.com/")))
+	assert.Empty(t, ruleSet.Lists())
+
+	_, _, err = LoadRuleSet(strings.NewReader(list), FailOn(ErrSkipHTML))
+	assert.True(t, errors.Is(err, ErrSkipHTML))
+	assert.EqualError(t, err, `line 2: HTML rules are skipped in "##.ad-banner"`)
+}
+
+func TestLoadRuleSetBadSelector(t *testing.T) {
+	list := "##.ad-banner\nexample.com##div:matches-css(width: 300px)\n"
+
+	set := CreateCosmeticSet()
+	_, report, err := LoadRuleSet(strings.NewReader(list), WithCosmeticSet(set))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, report.CosmeticRules)
+	assert.Equal(t, map[error]int{ErrBadSelector: 1}, report.Skipped)
+	assert.Len(t, report.Errors, 1)
+	assert.Equal(t, 2, report.Errors[0].Line)
+}
+
+func TestLoadRuleSetLongLine(t *testing.T) {
+	list := "||ads.example.com^\n##" + strings.Repeat(".ad-banner,", 10000) + ".ad\n||tracker.com^\n"
+
+	ruleSet, report, err := LoadRuleSet(strings.NewReader(list))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, report.Lines)
+	assert.Equal(t, 2, report.Rules)
+	assert.False(t, ruleSet.Allow(reqFromURL("http://tracker.com/")))
+}
+
+func TestRuleSetAddList(t *testing.T) {
+	ruleSet := CreateRuleSet()
+	_, err := ruleSet.AddList(strings.NewReader("||ads.example.com^"))
+	assert.NoError(t, err)
+	_, err = ruleSet.AddList(strings.NewReader("||tracker.com^"))
+	assert.NoError(t, err)
+
+	assert.False(t, ruleSet.Allow(reqFromURL("http://ads.example.com/")))
+	assert.False(t, ruleSet.Allow(reqFromURL("http://tracker.com/")))
+	assert.True(t, ruleSet.Allow(reqFromURL("http://example.com/")))
+}
+
+func TestLoadListMetadata(t *testing.T) {
+	list := strings.Join([]string{
+		"[Adblock Plus 2.0]",
+		"! Version: 202010161044",
+		"! Title: EasyList",
+		"! Last modified: 16 Oct 2020 10:44 UTC",
+		"! Expires: 4 days (update frequency)",
+		"! Homepage: https://easylist.to/",
+		"! Licence: https://easylist.to/pages/licence.html",
+		"!",
+		"! Please report any unblocked adverts or problems",
+		"||ads.example.com^",
+		"! Title: Not a header",
+	}, "\n")
+
+	ruleSet, report, err := LoadRuleSet(strings.NewReader(list))
+	assert.NoError(t, err)
+	expected := ListMetadata{
+		Format:       "Adblock Plus 2.0",
+		Title:        "EasyList",
+		Version:      "202010161044",
+		Expires:      4 * 24 * time.Hour,
+		Homepage:     "https://easylist.to/",
+		License:      "https://easylist.to/pages/licence.html",
+		LastModified: time.Date(2020, time.October, 16, 10, 44, 0, 0, time.UTC),
+	}
+	assert.Equal(t, expected.LastModified.Unix(), report.Metadata.LastModified.Unix())
+	report.Metadata.LastModified = expected.LastModified
+	assert.Equal(t, expected, report.Metadata)
+
+	_, err = ruleSet.AddList(strings.NewReader("! Title: Overrides\n! Expires: 12 hours\n@@||ads.example.com^"))
+	assert.NoError(t, err)
+	lists := ruleSet.Lists()
+	assert.Len(t, lists, 2)
+	assert.Equal(t, "EasyList", lists[0].Title)
+	assert.Equal(t, "Overrides", lists[1].Title)
+	assert.Equal(t, 12*time.Hour, lists[1].Expires)
+	assert.True(t, lists[1].LastModified.IsZero())
+}
+
+func TestLoadRuleSetSource(t *testing.T) {
+	ruleSet, _, err := LoadRuleSet(strings.NewReader("! Title: EasyList\n||ads.example.com^"))
+	assert.NoError(t, err)
+	_, err = ruleSet.AddList(strings.NewReader("@@||ads.example.com^$script"), WithSource("overrides.txt"))
+	assert.NoError(t, err)
+
+	result := ruleSet.Check(reqFromURL("http://ads.example.com/file.js"))
+	assert.True(t, result.Allowed)
+	assert.Equal(t, "EasyList", result.Rule.Source())
+	assert.Equal(t, "overrides.txt", result.Exception.Source())
+	assert.Equal(t, "@@||ads.example.com^$script", result.Exception.Text())
+}
@@ -0,0 +1,137 @@
+package adblockgoparser
+
+import (
+	"encoding/base64"
+	"sort"
+)
+
+// Resource is a neutered file served from memory in place of a request
+// redirected by a $redirect rule
+type Resource struct {
+	// Name of the resource, as used by uBlock Origin
+	Name string
+	// Aliases are the other names lists use for the resource
+	Aliases []string
+	// ContentType of the body, to be sent in the response headers
+	ContentType string
+	// Body of the response
+	Body []byte
+}
+
+// resources is the library of redirect resources
+var resources = []*Resource{
+	{
+		Name:        "1x1.gif",
+		Aliases:     []string{"1x1-transparent.gif", "abp-resource:1x1-transparent-gif"},
+		ContentType: "image/gif",
+		Body:        mustDecodeBase64("R0lGODlhAQABAIAAAAAAAP///yH5BAEAAAAALAAAAAABAAEAAAIBRAA7"),
+	},
+	{
+		Name:        "2x2.png",
+		Aliases:     []string{"2x2-transparent.png", "abp-resource:2x2-transparent-png"},
+		ContentType: "image/png",
+		Body:        mustDecodeBase64("iVBORw0KGgoAAAANSUhEUgAAAAIAAAACCAYAAABytg0kAAAAC0lEQVR42mNgQAcAABIAAeRVjecAAAAASUVORK5CYII="),
+	},
+	{
+		Name:        "3x2.png",
+		Aliases:     []string{"3x2-transparent.png", "abp-resource:3x2-transparent-png"},
+		ContentType: "image/png",
+		Body:        mustDecodeBase64("iVBORw0KGgoAAAANSUhEUgAAAAMAAAACCAYAAACddGYaAAAAC0lEQVR42mNgwAUAABoAAS+Yl6YAAAAASUVORK5CYII="),
+	},
+	{
+		Name:        "32x32.png",
+		Aliases:     []string{"32x32-transparent.png", "abp-resource:32x32-transparent-png"},
+		ContentType: "image/png",
+		Body:        mustDecodeBase64("iVBORw0KGgoAAAANSUhEUgAAACAAAAAgCAYAAABzenr0AAAAGklEQVR42u3BAQEAAACCIP+vbkhAAQAAAO8GECAAAcm1w7EAAAAASUVORK5CYII="),
+	},
+	{
+		Name:        "noop.js",
+		Aliases:     []string{"noopjs", "abp-resource:blank-js"},
+		ContentType: "application/javascript",
+		Body:        []byte("(function() {\n    'use strict';\n})();\n"),
+	},
+	{
+		Name:        "noop.css",
+		Aliases:     []string{"noopcss", "abp-resource:blank-css"},
+		ContentType: "text/css",
+		Body:        []byte{},
+	},
+	{
+		Name:        "noop.txt",
+		Aliases:     []string{"nooptext", "abp-resource:blank-text"},
+		ContentType: "text/plain",
+		Body:        []byte{},
+	},
+	{
+		Name:        "noop.html",
+		Aliases:     []string{"noopframe", "abp-resource:blank-html"},
+		ContentType: "text/html",
+		Body:        []byte("<!DOCTYPE html>\n<html><head><title></title></head><body></body></html>\n"),
+	},
+	{
+		Name:        "noop.json",
+		Aliases:     []string{"noopjson"},
+		ContentType: "application/json",
+		Body:        []byte("{}"),
+	},
+	{
+		Name:        "empty",
+		ContentType: "text/plain",
+		Body:        []byte{},
+	},
+	{
+		Name:        "noop-vast2.xml",
+		Aliases:     []string{"noopvast-2.0"},
+		ContentType: "application/xml",
+		Body:        []byte(`<?xml version="1.0" encoding="UTF-8"?><VAST version="2.0"/>`),
+	},
+	{
+		Name:        "noop-vast3.xml",
+		Aliases:     []string{"noopvast-3.0"},
+		ContentType: "application/xml",
+		Body:        []byte(`<?xml version="1.0" encoding="UTF-8"?><VAST version="3.0"/>`),
+	},
+	{
+		Name:        "noop-vast4.xml",
+		Aliases:     []string{"noopvast-4.0"},
+		ContentType: "application/xml",
+		Body:        []byte(`<?xml version="1.0" encoding="UTF-8"?><VAST version="4.0"/>`),
+	},
+}
+
+// resourcesByName indexes the resources by name and aliases
+var resourcesByName = func() map[string]*Resource {
+	byName := map[string]*Resource{}
+	for _, resource := range resources {
+		byName[resource.Name] = resource
+		for _, alias := range resource.Aliases {
+			byName[alias] = resource
+		}
+	}
+	return byName
+}()
+
+func mustDecodeBase64(text string) []byte {
+	data, err := base64.StdEncoding.DecodeString(text)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
+// LookupResource returns the redirect resource with the given name or alias
+func LookupResource(name string) (*Resource, bool) {
+	resource, ok := resourcesByName[name]
+	return resource, ok
+}
+
+// ResourceNames returns the sorted names of the redirect resources, without
+// their aliases
+func ResourceNames() []string {
+	names := make([]string, 0, len(resources))
+	for _, resource := range resources {
+		names = append(names, resource.Name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,44 @@
+package adblockgoparser
+
+import (
+	"bytes"
+	"image/gif"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLookupResource(t *testing.T) {
+	resource, ok := LookupResource("noopjs")
+	assert.True(t, ok)
+	assert.Equal(t, "noop.js", resource.Name)
+
+	alias, ok := LookupResource("abp-resource:blank-js")
+	assert.True(t, ok)
+	assert.Equal(t, resource, alias)
+
+	_, ok = LookupResource("unknown.js")
+	assert.False(t, ok)
+}
+
+func TestResourceImages(t *testing.T) {
+	resource, _ := LookupResource("1x1.gif")
+	image, err := gif.Decode(bytes.NewReader(resource.Body))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, image.Bounds().Dx())
+
+	for name, size := range map[string][2]int{"2x2.png": {2, 2}, "3x2.png": {3, 2}, "32x32.png": {32, 32}} {
+		resource, _ := LookupResource(name)
+		image, err := png.Decode(bytes.NewReader(resource.Body))
+		assert.NoError(t, err, name)
+		assert.Equal(t, size, [2]int{image.Bounds().Dx(), image.Bounds().Dy()}, name)
+	}
+}
+
+func TestResourceNames(t *testing.T) {
+	names := ResourceNames()
+	assert.Contains(t, names, "noop.js")
+	assert.Contains(t, names, "1x1.gif")
+	assert.NotContains(t, names, "noopjs")
+}
@@ -0,0 +1,100 @@
+package adblockgoparser
+
+import (
+	"net/url"
+	"strings"
+)
+
+// Rewrite removes from the request URL the query parameters matched by
+// $removeparam rules. It returns the cleaned URL, and false when nothing was
+// removed. Exceptions with $removeparam disable the rules with the same
+// value, or all of them when they have none.
+func (ruleSet *RuleSet) Rewrite(req *Request) (*url.URL, bool) {
+	if req.URL.RawQuery == "" {
+		return req.URL, false
+	}
+	// The normalized request is only used to match the rules, the URL
+	// returned keeps its host as given
+	normalized := normalizeRequest(req)
+	page := normalizeRequest(&Request{URL: documentURL(normalized), ResourceType: ResourceDocument})
+	if ruleSet.pageWhite["document"].Match(page) != nil {
+		return req.URL, false
+	}
+
+	exceptions := ruleSet.removeParamWhite.MatchAll(normalized)
+	var rules []*RuleAdBlock
+	for _, rule := range ruleSet.removeParamBlack.MatchAll(normalized) {
+		if !isOptionExcepted(exceptions, rule, "removeparam") {
+			rules = append(rules, rule)
+		}
+	}
+	if len(rules) == 0 {
+		return req.URL, false
+	}
+
+	params := strings.Split(req.URL.RawQuery, "&")
+	var kept []string
+	for _, param := range params {
+		if !removesParam(rules, param) {
+			kept = append(kept, param)
+		}
+	}
+	if len(kept) == len(params) {
+		return req.URL, false
+	}
+
+	rewritten := *req.URL
+	rewritten.RawQuery = strings.Join(kept, "&")
+	rewritten.ForceQuery = false
+	return &rewritten, true
+}
+
+// isOptionExcepted tells if one of the exceptions disables the value of the
+// option in the rule, exceptions without value disabling all of them
+func isOptionExcepted(exceptions []*RuleAdBlock, rule *RuleAdBlock, option string) bool {
+	for _, exception := range exceptions {
+		if value, ok := exception.values[option]; !ok || value == rule.values[option] {
+			return true
+		}
+	}
+	return false
+}
+
+func removesParam(rules []*RuleAdBlock, param string) bool {
+	for _, rule := range rules {
+		if rule.removesParam(param) {
+			return true
+		}
+	}
+	return false
+}
+
+// removesParam tells if the rule removes the query parameter, given as
+// name=value. Rules without value remove all the parameters, the ones with a
+// regex match it against name=value, and ~ keeps the matching parameters.
+func (rule *RuleAdBlock) removesParam(param string) bool {
+	value, ok := rule.values["removeparam"]
+	if !ok {
+		return true
+	}
+	negated := strings.HasPrefix(value, "~")
+	value = strings.TrimPrefix(value, "~")
+
+	var matched bool
+	if rule.paramRegex != nil {
+		if decoded, err := url.QueryUnescape(param); err == nil {
+			param = decoded
+		}
+		matched = rule.paramRegex.MatchString(param)
+	} else {
+		name := param
+		if index := strings.Index(param, "="); index >= 0 {
+			name = param[:index]
+		}
+		if decoded, err := url.QueryUnescape(name); err == nil {
+			name = decoded
+		}
+		matched = name == value
+	}
+	return matched != negated
+}
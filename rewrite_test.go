@@ -0,0 +1,109 @@
+package adblockgoparser
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsingRemoveParam(t *testing.T) {
+	rule, err := ParseRule("$removeparam=utm_source")
+	assert.NoError(t, err)
+	value, _ := rule.OptionValue("removeparam")
+	assert.Equal(t, "utm_source", value)
+	assert.Equal(t, "$removeparam=utm_source", rule.String())
+
+	rule, err = ParseRule(`||example.com^$removeparam=/^(utm_\w+|ref\,src)=/i`)
+	assert.NoError(t, err)
+	value, _ = rule.OptionValue("removeparam")
+	assert.Equal(t, `/^(utm_\w+|ref,src)=/i`, value)
+	assert.Equal(t, `||example.com^$removeparam=/^(utm_\w+|ref\,src)=/i`, rule.String())
+
+	_, err = ParseRule("$removeparam=/(/")
+	assert.True(t, errors.Is(err, ErrBadRegex))
+	_, err = ParseRule("$~removeparam=fbclid")
+	assert.True(t, errors.Is(err, ErrUnsupportedRule))
+}
+
+func TestRewrite(t *testing.T) {
+	rules := []string{
+		"$removeparam=fbclid",
+		"$removeparam=/^utm_/",
+		"||shop.com^$removeparam=~id",
+		"||keep.com^$removeparam=ref",
+		"@@||keep.com^$removeparam=fbclid",
+		"@@||raw.com^$removeparam",
+		"@@||partner.com^$document",
+	}
+	ruleSet, err := newRuleSetFromList(rules)
+	assert.NoError(t, err)
+
+	rewritten, ok := ruleSet.Rewrite(reqFromURL("http://example.com/page?id=1&utm_source=news&fbclid=abc&utm_medium=mail"))
+	assert.True(t, ok)
+	assert.Equal(t, "http://example.com/page?id=1", rewritten.String())
+
+	rewritten, ok = ruleSet.Rewrite(reqFromURL("http://example.com/page?utm_source=news"))
+	assert.True(t, ok)
+	assert.Equal(t, "http://example.com/page", rewritten.String())
+
+	// Negated names keep only the named parameter
+	rewritten, ok = ruleSet.Rewrite(reqFromURL("http://www.shop.com/item?color=red&id=42&size=m"))
+	assert.True(t, ok)
+	assert.Equal(t, "http://www.shop.com/item?id=42", rewritten.String())
+
+	// Exceptions disable the rules with the same value, or all of them
+	rewritten, ok = ruleSet.Rewrite(reqFromURL("http://keep.com/?fbclid=abc&ref=home&utm_campaign=x"))
+	assert.True(t, ok)
+	assert.Equal(t, "http://keep.com/?fbclid=abc", rewritten.String())
+
+	rewritten, ok = ruleSet.Rewrite(reqFromURL("http://raw.com/?fbclid=abc"))
+	assert.False(t, ok)
+	assert.Equal(t, "http://raw.com/?fbclid=abc", rewritten.String())
+
+	_, ok = ruleSet.Rewrite(reqFromURL("http://partner.com/?fbclid=abc"))
+	assert.False(t, ok)
+
+	// Nothing to remove
+	rewritten, ok = ruleSet.Rewrite(reqFromURL("http://example.com/page?q=go"))
+	assert.False(t, ok)
+	assert.Equal(t, "http://example.com/page?q=go", rewritten.String())
+
+	// Rules with $removeparam don't block
+	assert.True(t, ruleSet.Allow(reqFromURL("http://example.com/page?fbclid=abc")))
+}
+
+func TestRewriteKeepsHost(t *testing.T) {
+	ruleSet, err := newRuleSetFromList([]string{"$removeparam=/^utm_/", "$removeparam=gclid,domain=bücher.de"})
+	assert.NoError(t, err)
+
+	reqURL, _ := url.Parse("http://bücher.de/a?utm_source=1&b=2&gclid=3")
+	rewritten, ok := ruleSet.Rewrite(&Request{URL: reqURL})
+	assert.True(t, ok)
+	assert.Equal(t, "bücher.de", rewritten.Hostname())
+	assert.Equal(t, "b=2", rewritten.RawQuery)
+	assert.Equal(t, "utm_source=1&b=2&gclid=3", reqURL.RawQuery)
+
+	reqURL, _ = url.Parse("http://Example.COM./a?utm_source=1")
+	rewritten, ok = ruleSet.Rewrite(&Request{URL: reqURL})
+	assert.True(t, ok)
+	assert.Equal(t, "Example.COM.", rewritten.Host)
+
+	// The URL given is returned when nothing is removed
+	reqURL, _ = url.Parse("http://bücher.de/a?b=2")
+	rewritten, ok = ruleSet.Rewrite(&Request{URL: reqURL})
+	assert.False(t, ok)
+	assert.Same(t, reqURL, rewritten)
+}
+
+func TestRewriteDomainRestriction(t *testing.T) {
+	ruleSet, err := newRuleSetFromList([]string{"$removeparam=gclid,domain=example.com"})
+	assert.NoError(t, err)
+
+	_, ok := ruleSet.Rewrite(reqFromURL("http://other.com/?gclid=1"))
+	assert.False(t, ok)
+	rewritten, ok := ruleSet.Rewrite(reqFromURL("http://www.example.com/?gclid=1&q=a"))
+	assert.True(t, ok)
+	assert.Equal(t, "http://www.example.com/?q=a", rewritten.String())
+}
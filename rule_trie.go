@@ -1,8 +1,13 @@
 package adblockgoparser
 
 import (
+	"net/url"
 	"path/filepath"
 	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/idna"
+	"golang.org/x/net/publicsuffix"
 )
 
 type matcher struct {
@@ -17,21 +22,35 @@ type pathMatcher struct {
 	rules []*RuleAdBlock
 }
 
+func newMatcher() *matcher {
+	return &matcher{
+		addressPartMatcher: &pathMatcher{
+			next: map[rune]*pathMatcher{},
+		},
+		domainNameMatcher: &pathMatcher{
+			next: map[rune]*pathMatcher{},
+		},
+		exactAddressMatcher: &pathMatcher{
+			next: map[rune]*pathMatcher{},
+		},
+	}
+}
+
 // Add Rule in a structured way to be able to match with Request
 func (m *matcher) Add(rule *RuleAdBlock) {
 	var runes []rune
 	text := strings.ToLower(rule.ruleText)
 	switch rule.ruleType {
-	case addressPart:
+	case AddressPart:
 		runes = []rune(text)
 		m.addressPartMatcher.addPath(runes, rule)
-	case domainName:
+	case DomainName:
 		runes = []rune(text[2 : len(text)-1])
 		m.domainNameMatcher.addPath(runes, rule)
-	case exactAddress:
+	case ExactAddress:
 		runes = []rune(text[1 : len(text)-1])
 		m.exactAddressMatcher.addPath(runes, rule)
-	case regexRule:
+	case RegexRule:
 		m.regexpRules = append(m.regexpRules, rule)
 	}
 }
@@ -55,47 +74,111 @@ func (pm *pathMatcher) addPath(runes []rune, rule *RuleAdBlock) {
 	pm.next[runes[0]].addPath(runes[1:], rule)
 }
 
-// Match the Request against all rules
-func (m *matcher) Match(req *Request) bool {
+// Remove the rules which are equal to the given one
+func (m *matcher) Remove(rule *RuleAdBlock) {
+	text := strings.ToLower(rule.ruleText)
+	key := rule.badFilterKey()
+	switch rule.ruleType {
+	case AddressPart:
+		m.addressPartMatcher.removePath([]rune(text), key)
+	case DomainName:
+		m.domainNameMatcher.removePath([]rune(text[2:len(text)-1]), key)
+	case ExactAddress:
+		m.exactAddressMatcher.removePath([]rune(text[1:len(text)-1]), key)
+	case RegexRule:
+		m.regexpRules = removeRules(m.regexpRules, key)
+	}
+}
+
+func (pm *pathMatcher) removePath(runes []rune, key string) {
+	// Rules are stored where addPath stopped
+	if len(runes) == 0 || string(runes[0]) == "^" {
+		pm.rules = removeRules(pm.rules, key)
+		return
+	}
+	if next, ok := pm.next[runes[0]]; ok {
+		next.removePath(runes[1:], key)
+	}
+}
+
+// removeRules filters out the rules with the given $badfilter key
+func removeRules(rules []*RuleAdBlock, key string) []*RuleAdBlock {
+	kept := rules[:0]
+	for _, rule := range rules {
+		if rule.badFilterKey() != key {
+			kept = append(kept, rule)
+		}
+	}
+	return kept
+}
+
+// Match the Request against all rules, returning the first rule which matches
+func (m *matcher) Match(req *Request) *RuleAdBlock {
+	var found *RuleAdBlock
+	m.each(req, func(rule *RuleAdBlock) bool {
+		found = rule
+		return false
+	})
+	return found
+}
+
+// MatchAll returns all the rules which match the Request
+func (m *matcher) MatchAll(req *Request) []*RuleAdBlock {
+	var rules []*RuleAdBlock
+	seen := map[*RuleAdBlock]struct{}{}
+	m.each(req, func(rule *RuleAdBlock) bool {
+		if _, ok := seen[rule]; !ok {
+			seen[rule] = struct{}{}
+			rules = append(rules, rule)
+		}
+		return true
+	})
+	return rules
+}
+
+// each calls fn with the rules matching the Request until it returns false
+func (m *matcher) each(req *Request, fn func(*RuleAdBlock) bool) {
 	// Match path
 	pathRunes := []rune(strings.ToLower(req.URL.Path))
 	for i := range pathRunes {
-		if m.addressPartMatcher.findNext(pathRunes[i:], req) {
-			return true
+		if !m.addressPartMatcher.findNext(pathRunes[i:], req, fn) {
+			return
 		}
 	}
 
 	// Match domain and subdomains
 	hnRunes := []rune(strings.ToLower(req.URL.Hostname()))
 	for i := range hnRunes {
-		if m.domainNameMatcher.findNext(hnRunes[i:], req) {
-			return true
+		if !m.domainNameMatcher.findNext(hnRunes[i:], req, fn) {
+			return
 		}
 	}
 
 	// Match exact address
 	URLRunes := []rune(strings.ToLower(req.URL.String()))
-	if m.exactAddressMatcher.findNext(URLRunes, req) {
-		return true
+	if !m.exactAddressMatcher.findNext(URLRunes, req, fn) {
+		return
 	}
 
 	// Match direct regexp
 	URL := req.URL.String()
 	for _, rule := range m.regexpRules {
-		if rule.regex.MatchString(URL) {
-			return true
+		if matchDomains(rule, req) && matchOptions(rule, req) && rule.regex.MatchString(URL) && !fn(rule) {
+			return
 		}
 	}
-	return false
 }
 
-func (pm *pathMatcher) findNext(runes []rune, req *Request) bool {
-	match := false
+// findNext calls fn with the matching rules along the path, it returns false
+// when fn stopped the search
+func (pm *pathMatcher) findNext(runes []rune, req *Request, fn func(*RuleAdBlock) bool) bool {
 	// If find some rules in the current rune, try to match
 	if len(pm.rules) != 0 {
 		for _, rule := range pm.rules {
 			if matchDomains(rule, req) && matchOptions(rule, req) && rule.regex.MatchString(req.URL.String()) { // This line need to be removed and add simpler validation
-				return true
+				if !fn(rule) {
+					return false
+				}
 			}
 		}
 	}
@@ -104,99 +187,229 @@ func (pm *pathMatcher) findNext(runes []rune, req *Request) bool {
 	if len(runes) != 0 {
 		// Go to the next expected rune
 		if _, ok := pm.next[runes[0]]; ok {
-			match = pm.next[runes[0]].findNext(runes[1:], req)
-			if match {
-				return true
+			if !pm.next[runes[0]].findNext(runes[1:], req, fn) {
+				return false
 			}
 		}
 	}
 
 	// If the current path match has a wildcard
-	if _, ok := pm.next['*']; ok && !match {
+	if _, ok := pm.next['*']; ok {
 		// Start ignoring characters from URL
 		for i := range runes {
-			match := pm.next['*'].findNext(runes[i:], req)
-			if match {
-				return true
+			if !pm.next['*'].findNext(runes[i:], req, fn) {
+				return false
 			}
 		}
 	}
 
-	// Return false if no rules match neither has a path to follow nor wildcard
-	return false
+	// Keep looking if no rules stopped the search
+	return true
 }
 
 func matchDomains(rule *RuleAdBlock, req *Request) bool {
 	allowedDomain := true
-	matchCase := false
-	hostname := req.URL.Hostname()
-	if _, matchCase = rule.options["match-case"]; !matchCase {
-		hostname = strings.ToLower(hostname)
-	}
-	if rule.ruleType == domainName {
-		if !strings.HasSuffix(hostname, rule.ruleText[2:len(rule.ruleText)-1]) {
+	if rule.ruleType == DomainName {
+		ruleHostname := rule.ruleText[2 : len(rule.ruleText)-1]
+		// Patterns with wildcards or paths are left to the regex
+		if !strings.ContainsAny(ruleHostname, "/*^:?|") && !matchHostname(normalizeHostname(req.URL.Hostname()), strings.ToLower(ruleHostname)) {
 			allowedDomain = false
 		}
 	}
-	if len(rule.domains) > 0 {
-		for domain, active := range rule.domains {
-			if !matchCase {
-				domain = strings.ToLower(domain)
-			}
-			if !(strings.HasSuffix(hostname, domain) == active) {
-				allowedDomain = false
-				break
-			}
-		}
+	if len(rule.domains) > 0 && !matchDocumentDomains(rule.domains, documentHostname(req)) {
+		allowedDomain = false
 	}
 	return allowedDomain
 }
 
+// matchDocumentDomains applies a domain= list to the page hostname. The most
+// specific entry matching the page decides, and when none does the rule only
+// applies if the list has no included domains.
+func matchDocumentDomains(domains map[string]bool, docHostname string) bool {
+	matched := ""
+	hasIncluded := false
+	for domain, active := range domains {
+		if active {
+			hasIncluded = true
+		}
+		if len(domain) > len(matched) && matchHostname(docHostname, domain) {
+			matched = domain
+		}
+	}
+	if matched == "" {
+		return !hasIncluded
+	}
+	return domains[matched]
+}
+
 func matchOptions(rule *RuleAdBlock, req *Request) bool {
-	matchOption := true
+	if rule.header != nil && !rule.header.match(req.ResponseHeader) {
+		return false
+	}
+	if len(rule.methods) > 0 && !matchMethod(rule.methods, req.Method) {
+		return false
+	}
+	if active, ok := rule.options["third-party"]; ok && isThirdParty(req) != active {
+		return false
+	}
+
+	// Type options are alternatives: the request must have one of the
+	// listed types, and must not have any of the negated ones
+	reqType := requestType(req)
+	includesTypes := false
+	for option, active := range rule.options {
+		optionType, ok := typeOptions[option]
+		if !ok {
+			continue
+		}
+		if optionType == reqType {
+			return active
+		}
+		if active {
+			includesTypes = true
+		}
+	}
+	// Popups are only blocked by rules asking for them explicitly
+	return !includesTypes && reqType != ResourcePopup
+}
+
+// matchMethod applies a $method list to the request method, which must be one
+// of the listed methods when some are not negated
+func matchMethod(methods map[string]bool, method string) bool {
+	method = strings.ToLower(method)
+	if method == "" {
+		method = "get"
+	}
+	if active, ok := methods[method]; ok {
+		return active
+	}
+	for _, active := range methods {
+		if active {
+			return false
+		}
+	}
+	return true
+}
+
+// requestType returns the type of the resource requested. If the request
+// doesn't define it, it is guessed from the file extension.
+func requestType(req *Request) ResourceType {
+	if req.ResourceType != ResourceUnknown {
+		return req.ResourceType
+	}
+	if req.IsXHR {
+		return ResourceXHR
+	}
+
 	path := strings.ToLower(req.URL.Path)
 	if strings.HasSuffix(path, ".gz") {
 		path = path[:len(path)-len(".gz")]
 	}
 
-	if len(rule.options) > 0 {
-		matchOption = false
-		for option, active := range rule.options {
-			switch {
-			case option == "xmlhttprequest":
-			case option == "third-party":
-			case option == "match-case":
-				matchOption = true
-			case option == "script":
-				switch filepath.Ext(path) {
-				case ".js":
-					return active
-				default:
-					return !active
-				}
-			case option == "image":
-				switch filepath.Ext(path) {
-				case ".jpg", ".jpeg", ".png", ".gif", ".webp", ".tiff", ".psd", ".raw", ".bmp", ".heif", ".indd", ".jpeg2000":
-					return active
-				default:
-					return !active
-				}
-			case option == "stylesheet":
-				switch filepath.Ext(path) {
-				case ".css":
-					return active
-				default:
-					return !active
-				}
-			case option == "font":
-				switch filepath.Ext(path) {
-				case ".otf", ".ttf", ".fnt":
-					return active
-				default:
-					return !active
-				}
+	switch filepath.Ext(path) {
+	case ".js":
+		return ResourceScript
+	case ".jpg", ".jpeg", ".png", ".gif", ".webp", ".tiff", ".psd", ".raw", ".bmp", ".heif", ".indd", ".jpeg2000":
+		return ResourceImage
+	case ".css":
+		return ResourceStylesheet
+	case ".otf", ".ttf", ".fnt":
+		return ResourceFont
+	case ".mp3", ".mp4", ".m4a", ".ogg", ".oga", ".ogv", ".webm", ".wav", ".flac":
+		return ResourceMedia
+	}
+	return ResourceUnknown
+}
+
+// documentURL returns the URL of the page which originated the request, taken
+// from the Referer header or, failing that, the Origin header. Documents, and
+// requests without any page context, are considered top level navigations, so
+// the page is the request itself.
+func documentURL(req *Request) *url.URL {
+	if req.ResourceType != ResourceDocument {
+		for _, rawURL := range []string{req.Referer, req.Origin} {
+			if rawURL == "" {
+				continue
+			}
+			if docURL, err := url.Parse(rawURL); err == nil && docURL.Hostname() != "" {
+				return docURL
+			}
+		}
+	}
+	return req.URL
+}
+
+// documentHostname returns the hostname of the page which originated the request
+func documentHostname(req *Request) string {
+	return normalizeHostname(documentURL(req).Hostname())
+}
+
+// registrableDomain returns the eTLD+1 of the hostname, or the hostname itself
+// when it has none (IP addresses, bare public suffixes, localhost...)
+func registrableDomain(hostname string) string {
+	domain, err := publicsuffix.EffectiveTLDPlusOne(hostname)
+	if err != nil {
+		return hostname
+	}
+	return domain
+}
+
+// isThirdParty tells if the request goes to a different registrable domain
+// than the page which originated it
+func isThirdParty(req *Request) bool {
+	hostname := normalizeHostname(req.URL.Hostname())
+	return registrableDomain(hostname) != registrableDomain(documentHostname(req))
+}
+
+// asciiHostname converts internationalized hostnames to punycode and drops the
+// trailing dot of fully qualified names
+func asciiHostname(hostname string) string {
+	hostname = strings.TrimSuffix(hostname, ".")
+	for _, r := range hostname {
+		if r >= utf8.RuneSelf {
+			if ascii, err := idna.Lookup.ToASCII(hostname); err == nil {
+				return ascii
 			}
+			break
 		}
 	}
-	return matchOption
+	return hostname
+}
+
+// normalizeHostname returns the hostname in the form used to compare domains
+func normalizeHostname(hostname string) string {
+	return strings.ToLower(asciiHostname(hostname))
+}
+
+// matchHostname tells if the hostname is the domain or one of its subdomains.
+func matchHostname(hostname, domain string) bool {
+	// Entities like example.* match the domain under any public suffix
+	if strings.HasSuffix(domain, ".*") {
+		suffix, _ := publicsuffix.PublicSuffix(hostname)
+		if !strings.HasSuffix(hostname, "."+suffix) {
+			return false
+		}
+		hostname = strings.TrimSuffix(hostname, "."+suffix)
+		domain = strings.TrimSuffix(domain, ".*")
+	}
+	return hostname == domain || strings.HasSuffix(hostname, "."+domain)
+}
+
+// normalizeRequest returns the request with its URL host in ASCII, so it can be
+// compared with the rules
+func normalizeRequest(req *Request) *Request {
+	hostname := req.URL.Hostname()
+	ascii := asciiHostname(hostname)
+	if ascii == hostname {
+		return req
+	}
+
+	reqURL := *req.URL
+	reqURL.Host = ascii
+	if port := req.URL.Port(); port != "" {
+		reqURL.Host += ":" + port
+	}
+	normalized := *req
+	normalized.URL = &reqURL
+	return &normalized
 }
@@ -3,8 +3,11 @@ package adblockgoparser
 import (
 	"errors"
 	"fmt"
+	"net/http"
 	"net/url"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -17,6 +20,8 @@ var (
 	ErrEmptyLine = errors.New("Empty lines are skipped")
 	// ErrUnsupportedRule Unsupported option rules are skipped
 	ErrUnsupportedRule = errors.New("Unsupported option rules are skipped")
+	// ErrBadRegex Rules which cannot be compiled to a regex are skipped
+	ErrBadRegex = errors.New("Cannot compile regex")
 
 	// Except domain
 	supportedOptions = []string{
@@ -27,6 +32,88 @@ var (
 		"third-party",
 		"xmlhttprequest",
 		"match-case",
+		"subdocument",
+		"media",
+		"object",
+		"ping",
+		"websocket",
+		"other",
+		"document",
+		"popup",
+		"important",
+		"badfilter",
+		"elemhide",
+		"generichide",
+		"genericblock",
+		"redirect",
+		"redirect-rule",
+		"removeparam",
+		"csp",
+		"header",
+		"method",
+	}
+	// Alternative names used by uBlock Origin and old lists
+	optionAliases = map[string]string{
+		"css":               "stylesheet",
+		"doc":               "document",
+		"frame":             "subdocument",
+		"xhr":               "xmlhttprequest",
+		"beacon":            "ping",
+		"object-subrequest": "object",
+		"ehide":             "elemhide",
+		"ghide":             "generichide",
+	}
+	// Options of exception rules which disable filtering on whole pages
+	pageOptions = []string{"document", "elemhide", "generichide", "genericblock"}
+	// Options which can't be negated
+	flagOptions = map[string]struct{}{
+		"important":     {},
+		"badfilter":     {},
+		"elemhide":      {},
+		"generichide":   {},
+		"genericblock":  {},
+		"redirect":      {},
+		"redirect-rule": {},
+		"removeparam":   {},
+		"csp":           {},
+		"header":        {},
+		"method":        {},
+	}
+	// Options which take a value, like $redirect=noop.js
+	valueOptions = map[string]struct{}{
+		"redirect":      {},
+		"redirect-rule": {},
+		"removeparam":   {},
+		"csp":           {},
+		"header":        {},
+		"method":        {},
+	}
+	// HTTP methods which can be given to $method
+	httpMethods = map[string]struct{}{
+		"connect": {},
+		"delete":  {},
+		"get":     {},
+		"head":    {},
+		"options": {},
+		"patch":   {},
+		"post":    {},
+		"put":     {},
+	}
+	// Options which restrict the rule to some kinds of request
+	typeOptions = map[string]ResourceType{
+		"image":          ResourceImage,
+		"script":         ResourceScript,
+		"stylesheet":     ResourceStylesheet,
+		"font":           ResourceFont,
+		"xmlhttprequest": ResourceXHR,
+		"subdocument":    ResourceSubdocument,
+		"media":          ResourceMedia,
+		"object":         ResourceObject,
+		"ping":           ResourcePing,
+		"websocket":      ResourceWebSocket,
+		"other":          ResourceOther,
+		"document":       ResourceDocument,
+		"popup":          ResourcePopup,
 	}
 	supportedOptionsPat = func() map[string]struct{} {
 		rv := map[string]struct{}{}
@@ -37,7 +124,96 @@ var (
 	}()
 )
 
-// Request has the expected data to be able to match the rules
+// ResourceType identifies the kind of resource a request is fetching
+type ResourceType int
+
+const (
+	// ResourceUnknown is guessed from the URL when matching
+	ResourceUnknown ResourceType = iota
+	// ResourceDocument top level page
+	ResourceDocument
+	// ResourceSubdocument page loaded in a frame
+	ResourceSubdocument
+	// ResourceScript JavaScript file
+	ResourceScript
+	// ResourceImage image file
+	ResourceImage
+	// ResourceStylesheet CSS file
+	ResourceStylesheet
+	// ResourceFont font file
+	ResourceFont
+	// ResourceMedia audio or video file
+	ResourceMedia
+	// ResourceObject content handled by browser plugins
+	ResourceObject
+	// ResourceXHR XMLHttpRequest or fetch call
+	ResourceXHR
+	// ResourceWebSocket WebSocket connection
+	ResourceWebSocket
+	// ResourcePing navigator.sendBeacon or hyperlink auditing
+	ResourcePing
+	// ResourceOther any other kind of request
+	ResourceOther
+	// ResourcePopup page opened in a new window, only matched by $popup rules
+	ResourcePopup
+)
+
+// ParseError describes a rule which could not be parsed, it wraps the
+// reason so it can be checked with errors.Is
+type ParseError struct {
+	// Line number of the rule in its list, 0 when parsed on its own
+	Line int
+	// Rule original text
+	Rule string
+	// Option which is not supported or has a bad value, if any
+	Option string
+	// Err reason of the failure, like ErrUnsupportedRule
+	Err error
+}
+
+func (e *ParseError) Error() string {
+	msg := e.Err.Error()
+	if e.Option != "" {
+		msg = fmt.Sprintf("%s: option %q", msg, e.Option)
+	}
+	msg = fmt.Sprintf("%s in %q", msg, e.Rule)
+	if e.Line > 0 {
+		msg = fmt.Sprintf("line %d: %s", e.Line, msg)
+	}
+	return msg
+}
+
+// Unwrap returns the reason of the failure
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// causeError is a reason of failure, like ErrBadRegex, caused by another
+// error. It is the reason for errors.Is and unwraps to the cause, so a
+// *syntax.Error can be found with errors.As.
+type causeError struct {
+	reason error
+	cause  error
+}
+
+func (e *causeError) Error() string {
+	return fmt.Sprintf("%v: %v", e.reason, e.cause)
+}
+
+// Is tells if the target is the reason of the failure
+func (e *causeError) Is(target error) bool {
+	return target == e.reason
+}
+
+// Unwrap returns the cause of the failure
+func (e *causeError) Unwrap() error {
+	return e.cause
+}
+
+// Request has the expected data to be able to match the rules.
+// The page which originated the request, used by the third-party and domain
+// options, is taken from Referer or Origin. When both are empty the request is
+// considered a top level navigation, and it is its own page.
 type Request struct {
 	// parsed full URL of the request
 	URL *url.URL
@@ -47,29 +223,61 @@ type Request struct {
 	Referer string
 	// Defines is request looks like XHLHttpRequest
 	IsXHR bool
+	// Kind of resource requested, guessed from IsXHR and the URL when unknown
+	ResourceType ResourceType
+	// Headers of the response, only known when checking it with CheckResponse
+	ResponseHeader http.Header
+	// HTTP method of the request, GET when empty
+	Method string
 }
 
 // RuleType type to identify the type of rule after parsing it
 type RuleType int
 
 const (
-	addressPart RuleType = iota
-	domainName
-	exactAddress
-	regexRule
+	// AddressPart pattern matching any part of the address
+	AddressPart RuleType = iota
+	// DomainName pattern anchored to the domain, like ||example.com^
+	DomainName
+	// ExactAddress pattern matching the whole address, like |http://example.com/|
+	ExactAddress
+	// RegexRule regular expression, like /banner\d+/
+	RegexRule
 )
 
+func (ruleType RuleType) String() string {
+	switch ruleType {
+	case AddressPart:
+		return "address part"
+	case DomainName:
+		return "domain name"
+	case ExactAddress:
+		return "exact address"
+	case RegexRule:
+		return "regex"
+	}
+	return fmt.Sprintf("RuleType(%d)", int(ruleType))
+}
+
 // RuleAdBlock object containing the rule string generated regex and parsed options
 type RuleAdBlock struct {
+	rawText     string
+	source      string
 	ruleText    string
 	regex       *regexp.Regexp
 	options     map[string]bool
 	isException bool
 	domains     map[string]bool
+	values      map[string]string
+	paramRegex  *regexp.Regexp
+	header      *headerCondition
+	methods     map[string]bool
 	ruleType    RuleType
 }
 
-// ParseRule parse and create a RuleAdBlock from the string
+// ParseRule parse and create a RuleAdBlock from the string.
+// Comments, HTML rules and empty lines return the matching sentinel error,
+// rules which cannot be used return a *ParseError.
 func ParseRule(ruleText string) (*RuleAdBlock, error) {
 	ruleText = strings.TrimSpace(ruleText)
 
@@ -81,14 +289,17 @@ func ParseRule(ruleText string) (*RuleAdBlock, error) {
 		return nil, ErrSkipComment
 	}
 
-	if strings.Contains(ruleText, "##") || strings.Contains(ruleText, "#@#") || strings.Contains(ruleText, "#?#") {
+	if strings.Contains(ruleText, "##") || strings.Contains(ruleText, "#@#") || strings.Contains(ruleText, "#?#") || strings.Contains(ruleText, "#@?#") ||
+		strings.Contains(ruleText, "#%#") || strings.Contains(ruleText, "#@%#") {
 		return nil, ErrSkipHTML
 	}
 
 	rule := &RuleAdBlock{
+		rawText:  ruleText,
 		ruleText: ruleText,
 		domains:  map[string]bool{},
 		options:  map[string]bool{},
+		values:   map[string]string{},
 	}
 
 	rule.isException = strings.HasPrefix(rule.ruleText, "@@")
@@ -100,94 +311,606 @@ func ParseRule(ruleText string) (*RuleAdBlock, error) {
 		parts := strings.SplitN(rule.ruleText, "$", 2)
 		rule.ruleText = parts[0]
 
-		for _, option := range strings.Split(parts[1], ",") {
+		for _, option := range splitOptions(parts[1]) {
+			rawOption := option
 			optionNegative := !strings.HasPrefix(option, "~")
 			option = strings.TrimPrefix(option, "~")
+			value, hasValue := "", false
+			if index := strings.Index(option, "="); index >= 0 {
+				option, value, hasValue = option[:index], option[index+1:], true
+			}
+			if name, ok := optionAliases[option]; ok {
+				option = name
+			}
 			_, supportedOption := supportedOptionsPat[option]
+			_, valueOption := valueOptions[option]
 
 			switch {
-			case strings.HasPrefix(option, "domain="):
-				for _, domain := range strings.Split(option[len("domain="):], "|") {
+			case option == "domain" && hasValue:
+				for _, domain := range strings.Split(value, "|") {
 					name := strings.TrimSpace(domain)
-					rule.domains[strings.TrimPrefix(name, "~")] = !strings.HasPrefix(name, "~")
+					if strings.TrimPrefix(name, "~") == "" {
+						return nil, &ParseError{Rule: ruleText, Option: "domain=", Err: ErrUnsupportedRule}
+					}
+					rule.domains[normalizeHostname(strings.TrimPrefix(name, "~"))] = !strings.HasPrefix(name, "~")
 				}
 			case !supportedOption:
-				return nil, ErrUnsupportedRule
+				return nil, &ParseError{Rule: ruleText, Option: rawOption, Err: ErrUnsupportedRule}
+			case isFlagOption(option) && !optionNegative,
+				isPageOption(option) && option != "document" && !rule.isException,
+				hasValue && !valueOption:
+				return nil, &ParseError{Rule: ruleText, Option: rawOption, Err: ErrUnsupportedRule}
+			case valueOption:
+				if err := rule.setOptionValue(option, value); err != nil {
+					return nil, &ParseError{Rule: ruleText, Option: rawOption, Err: err}
+				}
 			default:
 				rule.options[option] = optionNegative
 			}
 		}
 	}
 
-	rule.ruleType = addressPart
+	if strings.HasPrefix(rule.ruleText, "||") {
+		rule.ruleText = "||" + asciiHostnamePattern(rule.ruleText[2:])
+	}
+
+	rule.ruleType = AddressPart
 	if strings.HasPrefix(rule.ruleText, "||") && strings.HasSuffix(rule.ruleText, "^") {
-		rule.ruleType = domainName
+		rule.ruleType = DomainName
 	}
 
 	if strings.HasPrefix(rule.ruleText, "|") && strings.HasSuffix(rule.ruleText, "|") {
-		rule.ruleType = exactAddress
+		rule.ruleType = ExactAddress
 	}
 
 	// The empty rule means the will block everything
 	// /{anything}/ mean regular expression. or define some other pattern to conflict to a path like /anything/
 	if rule.ruleText == "" || (strings.HasPrefix(rule.ruleText, "/") && strings.HasSuffix(rule.ruleText, "/")) {
-		rule.ruleType = regexRule
+		rule.ruleType = RegexRule
 	}
 
 	re, err := regexp.Compile(ruleToRegexp(rule))
 	if err != nil {
-		return nil, fmt.Errorf("Cannot compile regex: %w", err)
+		return nil, &ParseError{Rule: ruleText, Err: &causeError{reason: ErrBadRegex, cause: err}}
 	}
 	rule.regex = re
 	return rule, nil
 }
 
+// Pattern returns the address pattern of the rule, without the exception
+// prefix and the options
+func (rule *RuleAdBlock) Pattern() string {
+	return rule.ruleText
+}
+
+// Type returns how the pattern is anchored
+func (rule *RuleAdBlock) Type() RuleType {
+	return rule.ruleType
+}
+
+// IsException tells if the rule allows the requests it matches
+func (rule *RuleAdBlock) IsException() bool {
+	return rule.isException
+}
+
+// Options returns the options of the rule, false for the negated ones. The
+// values of options like $redirect are given by OptionValue.
+func (rule *RuleAdBlock) Options() map[string]bool {
+	options := make(map[string]bool, len(rule.options))
+	for option, active := range rule.options {
+		options[option] = active
+	}
+	return options
+}
+
+// OptionValue returns the value given to an option, like noop.js for
+// $redirect=noop.js
+func (rule *RuleAdBlock) OptionValue(option string) (string, bool) {
+	value, ok := rule.values[option]
+	return value, ok
+}
+
+// setOptionValue validates and sets the value of an option taking one
+func (rule *RuleAdBlock) setOptionValue(option, value string) error {
+	switch option {
+	case "redirect", "redirect-rule":
+		// Exceptions without resource disable all the redirects
+		if value == "" && rule.isException {
+			break
+		}
+		if _, _, ok := parseRedirect(value); !ok {
+			return ErrUnsupportedRule
+		}
+	case "csp":
+		// Exceptions without policy disable all the policies. Lists can't
+		// make the browser report to a third party.
+		if value == "" && !rule.isException || strings.Contains(value, "report-") {
+			return ErrUnsupportedRule
+		}
+	case "header":
+		// Exceptions without header disable all the header rules
+		if value == "" && rule.isException {
+			break
+		}
+		header, err := parseHeaderCondition(value)
+		if err != nil {
+			return err
+		}
+		rule.header = header
+	case "method":
+		methods, ok := parseMethods(value)
+		if !ok {
+			return ErrUnsupportedRule
+		}
+		rule.methods = methods
+		value = strings.ToLower(value)
+	case "removeparam":
+		if pattern, flags, ok := regexLiteral(strings.TrimPrefix(value, "~")); ok {
+			re, err := regexp.Compile(flags + pattern)
+			if err != nil {
+				return &causeError{reason: ErrBadRegex, cause: err}
+			}
+			rule.paramRegex = re
+		}
+	}
+	rule.options[option] = true
+	if value != "" {
+		rule.values[option] = value
+	}
+	return nil
+}
+
+// IncludedDomains returns the sorted domains the rule is restricted to
+func (rule *RuleAdBlock) IncludedDomains() []string {
+	return domainList(rule.domains, true)
+}
+
+// ExcludedDomains returns the sorted domains the rule doesn't apply to
+func (rule *RuleAdBlock) ExcludedDomains() []string {
+	return domainList(rule.domains, false)
+}
+
+// String returns the rule in canonical filter syntax: options and domains
+// sorted by name, negated ones prefixed with ~
+func (rule *RuleAdBlock) String() string {
+	text := rule.ruleText
+	if rule.isException {
+		text = "@@" + text
+	}
+	if options := rule.optionTexts(); len(options) > 0 {
+		text += "$" + strings.Join(options, ",")
+	}
+	return text
+}
+
+// optionTexts returns the options of the rule in canonical syntax
+func (rule *RuleAdBlock) optionTexts() []string {
+	var options []string
+	for option, active := range rule.options {
+		if !active {
+			option = "~" + option
+		}
+		if value, ok := rule.values[option]; ok {
+			option += "=" + strings.Replace(value, ",", `\,`, -1)
+		}
+		options = append(options, option)
+	}
+
+	if len(rule.domains) > 0 {
+		domains := make([]string, 0, len(rule.domains))
+		for domain, active := range rule.domains {
+			if !active {
+				domain = "~" + domain
+			}
+			domains = append(domains, domain)
+		}
+		sort.Slice(domains, func(i, j int) bool {
+			return strings.TrimPrefix(domains[i], "~") < strings.TrimPrefix(domains[j], "~")
+		})
+		options = append(options, "domain="+strings.Join(domains, "|"))
+	}
+
+	sort.Slice(options, func(i, j int) bool {
+		return strings.TrimPrefix(options[i], "~") < strings.TrimPrefix(options[j], "~")
+	})
+	return options
+}
+
+// badFilterTarget returns the rule disabled by a $badfilter rule
+func (rule *RuleAdBlock) badFilterTarget() *RuleAdBlock {
+	target := *rule
+	target.options = rule.Options()
+	delete(target.options, "badfilter")
+	return &target
+}
+
+// badFilterKey identifies the rule among the ones disabled by $badfilter,
+// patterns are compared whatever their case unless the rule has $match-case.
+// Regex patterns are kept, their escapes depend on the case.
+func (rule *RuleAdBlock) badFilterKey() string {
+	if _, ok := rule.options["match-case"]; ok || rule.ruleType == RegexRule {
+		return rule.String()
+	}
+	lower := *rule
+	lower.ruleText = strings.ToLower(rule.ruleText)
+	return lower.String()
+}
+
+// Text returns the rule as it was written in the list
+func (rule *RuleAdBlock) Text() string {
+	return rule.rawText
+}
+
+// Source returns the name of the list the rule was loaded from, if any
+func (rule *RuleAdBlock) Source() string {
+	return rule.source
+}
+
+// asciiHostnamePattern converts the hostname at the start of a || pattern to
+// punycode, keeping the rest of the pattern untouched
+func asciiHostnamePattern(pattern string) string {
+	end := strings.IndexAny(pattern, "/^*:?|")
+	if end < 0 {
+		end = len(pattern)
+	}
+	hostname := pattern[:end]
+	if ascii := asciiHostname(hostname); ascii != strings.TrimSuffix(hostname, ".") {
+		return ascii + pattern[end:]
+	}
+	return pattern
+}
+
+// parseMethods parses the value of $method, HTTP methods separated by |,
+// negated with ~
+func parseMethods(value string) (map[string]bool, bool) {
+	if value == "" {
+		return nil, false
+	}
+	methods := map[string]bool{}
+	for _, method := range strings.Split(strings.ToLower(value), "|") {
+		name := strings.TrimPrefix(method, "~")
+		if _, ok := httpMethods[name]; !ok {
+			return nil, false
+		}
+		methods[name] = !strings.HasPrefix(method, "~")
+	}
+	return methods, true
+}
+
+// splitOptions splits the options of a rule on commas, commas inside values
+// being escaped with a backslash
+func splitOptions(text string) []string {
+	var options []string
+	var option strings.Builder
+	for i := 0; i < len(text); i++ {
+		switch {
+		case text[i] == '\\' && i+1 < len(text) && text[i+1] == ',':
+			option.WriteByte(',')
+			i++
+		case text[i] == ',':
+			options = append(options, option.String())
+			option.Reset()
+		default:
+			option.WriteByte(text[i])
+		}
+	}
+	return append(options, option.String())
+}
+
+// regexLiteral splits a /pattern/flags value, flags are returned as a regex
+// prefix like (?i)
+func regexLiteral(value string) (string, string, bool) {
+	end := strings.LastIndex(value, "/")
+	if !strings.HasPrefix(value, "/") || end < 1 {
+		return "", "", false
+	}
+	flags := value[end+1:]
+	if strings.Trim(flags, "i") != "" {
+		return "", "", false
+	}
+	if flags != "" {
+		flags = "(?i)"
+	}
+	return value[1:end], flags, true
+}
+
+func isFlagOption(option string) bool {
+	_, ok := flagOptions[option]
+	return ok
+}
+
+func isPageOption(option string) bool {
+	for _, pageOption := range pageOptions {
+		if option == pageOption {
+			return true
+		}
+	}
+	return false
+}
+
 // RuleSet handle the structure to match whitelist and blacklist
 type RuleSet struct {
-	white *matcher
-	black *matcher
+	white          *matcher
+	black          *matcher
+	genericBlack   *matcher
+	importantWhite *matcher
+	importantBlack *matcher
+	// Rules with $redirect or $redirect-rule, and their exceptions
+	redirectBlack *matcher
+	redirectWhite *matcher
+	// Rules with $removeparam, and their exceptions
+	removeParamBlack *matcher
+	removeParamWhite *matcher
+	// Rules with $csp, and their exceptions
+	cspBlack *matcher
+	cspWhite *matcher
+	// Rules with $header, and their exceptions
+	headerBlack *matcher
+	headerWhite *matcher
+	// Exceptions matching pages, by page option
+	pageWhite map[string]*matcher
+	// Keys of the rules disabled by $badfilter
+	badFilters map[string]struct{}
+	lists      []ListMetadata
 }
 
-// AddRule Adds rule in the correct matcher
+// AddRule Adds rule in the correct matcher.
+// A rule with $badfilter disables the same rule without it, whether it was
+// added before or after.
 func (ruleSet *RuleSet) AddRule(rule *RuleAdBlock) {
-	if !rule.isException {
-		ruleSet.black.Add(rule)
+	if _, ok := rule.options["badfilter"]; ok {
+		target := rule.badFilterTarget()
+		ruleSet.badFilters[target.badFilterKey()] = struct{}{}
+		for _, m := range ruleSet.matchersFor(target) {
+			m.Remove(target)
+		}
+		return
+	}
+	if _, ok := ruleSet.badFilters[rule.badFilterKey()]; ok {
+		return
+	}
+	for _, m := range ruleSet.matchersFor(rule) {
+		m.Add(rule)
+	}
+}
+
+// matchersFor returns the matchers where the rule belongs. A $redirect rule
+// blocks the request and redirects it, a $redirect-rule one only redirects
+// the requests blocked by other rules. $removeparam and $csp rules never block,
+// $header ones only block responses.
+func (ruleSet *RuleSet) matchersFor(rule *RuleAdBlock) []*matcher {
+	_, redirect := rule.options["redirect"]
+	_, redirectRule := rule.options["redirect-rule"]
+	_, removeParam := rule.options["removeparam"]
+	_, csp := rule.options["csp"]
+	_, header := rule.options["header"]
+	switch {
+	case header && rule.isException:
+		return []*matcher{ruleSet.headerWhite}
+	case header:
+		return []*matcher{ruleSet.headerBlack}
+	case csp && rule.isException:
+		return []*matcher{ruleSet.cspWhite}
+	case csp:
+		return []*matcher{ruleSet.cspBlack}
+	case removeParam && rule.isException:
+		return []*matcher{ruleSet.removeParamWhite}
+	case removeParam:
+		return []*matcher{ruleSet.removeParamBlack}
+	case (redirect || redirectRule) && rule.isException:
+		return []*matcher{ruleSet.redirectWhite}
+	case redirectRule:
+		return []*matcher{ruleSet.redirectBlack}
+	case redirect:
+		return append(ruleSet.blockingMatchersFor(rule), ruleSet.redirectBlack)
 	}
+	return ruleSet.blockingMatchersFor(rule)
+}
+
+func (ruleSet *RuleSet) blockingMatchersFor(rule *RuleAdBlock) []*matcher {
 	if rule.isException {
-		ruleSet.white.Add(rule)
+		var matchers []*matcher
+		for _, option := range pageOptions {
+			if active := rule.options[option]; active {
+				matchers = append(matchers, ruleSet.pageWhite[option])
+			}
+		}
+		if len(matchers) > 0 {
+			return matchers
+		}
+	}
+
+	_, important := rule.options["important"]
+	switch {
+	case important && rule.isException:
+		return []*matcher{ruleSet.importantWhite}
+	case important:
+		return []*matcher{ruleSet.importantBlack}
+	case rule.isException:
+		return []*matcher{ruleSet.white}
+	case len(rule.IncludedDomains()) == 0:
+		return []*matcher{ruleSet.genericBlack}
+	default:
+		return []*matcher{ruleSet.black}
+	}
+}
+
+// PageExceptions tells which filtering is disabled on a page by exception
+// rules with page options
+type PageExceptions struct {
+	// Document disables all filtering, requests from the page are allowed
+	Document bool
+	// ElemHide disables element hiding
+	ElemHide bool
+	// GenericHide disables element hiding rules without domain restriction
+	GenericHide bool
+	// GenericBlock disables blocking rules without domain restriction
+	GenericBlock bool
+}
+
+// CosmeticDisabled tells if no element hiding should happen on the page
+func (exceptions PageExceptions) CosmeticDisabled() bool {
+	return exceptions.Document || exceptions.ElemHide
+}
+
+// PageExceptions returns the filtering disabled on the page
+func (ruleSet *RuleSet) PageExceptions(pageURL *url.URL) PageExceptions {
+	page := normalizeRequest(&Request{URL: pageURL, ResourceType: ResourceDocument})
+	return PageExceptions{
+		Document:     ruleSet.pageWhite["document"].Match(page) != nil,
+		ElemHide:     ruleSet.pageWhite["elemhide"].Match(page) != nil,
+		GenericHide:  ruleSet.pageWhite["generichide"].Match(page) != nil,
+		GenericBlock: ruleSet.pageWhite["genericblock"].Match(page) != nil,
 	}
 }
 
+// Result explains the decision taken for a request
+type Result struct {
+	// Allowed tells if the request can proceed
+	Allowed bool
+	// Rule is the blocking rule which matched the request, if any
+	Rule *RuleAdBlock
+	// Exception is the rule which allowed the request despite Rule, if any
+	Exception *RuleAdBlock
+	// Redirect is the resource to serve instead of the blocked request, if any
+	Redirect *Resource
+	// RedirectRule is the $redirect or $redirect-rule rule giving Redirect
+	RedirectRule *RuleAdBlock
+}
+
+// Check matches the request against the rules and returns the decision
+// along with the rules which took it. Rules with $important are checked first
+// and only exceptions with $important can override them. Otherwise exceptions
+// with $document allow every request from the pages they match. Blocked
+// requests are redirected when a $redirect or $redirect-rule rule matches.
+func (ruleSet *RuleSet) Check(req *Request) Result {
+	req = normalizeRequest(req)
+	result := ruleSet.check(req)
+	if !result.Allowed {
+		result.RedirectRule, result.Redirect = ruleSet.redirect(req)
+	}
+	return result
+}
+
+func (ruleSet *RuleSet) check(req *Request) Result {
+	result := Result{Allowed: true}
+
+	// Important rules can only be overridden by important exceptions
+	if result.Rule = ruleSet.importantBlack.Match(req); result.Rule != nil {
+		if result.Exception = ruleSet.importantWhite.Match(req); result.Exception == nil {
+			result.Allowed = false
+		}
+		return result
+	}
+
+	page := normalizeRequest(&Request{URL: documentURL(req), ResourceType: ResourceDocument})
+	if result.Rule = ruleSet.black.Match(req); result.Rule == nil {
+		if ruleSet.pageWhite["genericblock"].Match(page) != nil {
+			return result
+		}
+		if result.Rule = ruleSet.genericBlack.Match(req); result.Rule == nil {
+			return result
+		}
+	}
+
+	result.Exception = ruleSet.matchException(req, page)
+	result.Allowed = result.Exception != nil
+	return result
+}
+
+// matchException returns the exception allowing a blocked request, from its
+// page with $document or for the request itself
+func (ruleSet *RuleSet) matchException(req, page *Request) *RuleAdBlock {
+	if exception := ruleSet.pageWhite["document"].Match(page); exception != nil {
+		return exception
+	}
+	if exception := ruleSet.white.Match(req); exception != nil {
+		return exception
+	}
+	return ruleSet.importantWhite.Match(req)
+}
+
+// redirect returns the redirect rule with the highest priority matching the
+// request, and its resource. Exceptions disable the redirects to their
+// resource, or all of them when they have none.
+func (ruleSet *RuleSet) redirect(req *Request) (*RuleAdBlock, *Resource) {
+	exceptions := ruleSet.redirectWhite.MatchAll(req)
+	var found *RuleAdBlock
+	var foundResource *Resource
+	foundPriority := 0
+	for _, rule := range ruleSet.redirectBlack.MatchAll(req) {
+		resource, priority, _ := parseRedirect(rule.redirectValue())
+		if isRedirectExcepted(exceptions, resource) {
+			continue
+		}
+		if found == nil || priority > foundPriority || priority == foundPriority && rule.String() < found.String() {
+			found, foundResource, foundPriority = rule, resource, priority
+		}
+	}
+	return found, foundResource
+}
+
+func isRedirectExcepted(exceptions []*RuleAdBlock, resource *Resource) bool {
+	for _, exception := range exceptions {
+		if value := exception.redirectValue(); value == "" {
+			return true
+		} else if excepted, _, _ := parseRedirect(value); excepted == resource {
+			return true
+		}
+	}
+	return false
+}
+
+// redirectValue returns the value of $redirect or $redirect-rule
+func (rule *RuleAdBlock) redirectValue() string {
+	if value, ok := rule.values["redirect"]; ok {
+		return value
+	}
+	return rule.values["redirect-rule"]
+}
+
+// parseRedirect parses the value of $redirect, a resource name optionally
+// followed by a priority, like noop.js:10. Names may contain colons, like
+// abp-resource:blank-js.
+func parseRedirect(value string) (*Resource, int, bool) {
+	name, priority := value, 0
+	if index := strings.LastIndex(value, ":"); index >= 0 {
+		if number, err := strconv.Atoi(value[index+1:]); err == nil {
+			name, priority = value[:index], number
+		}
+	}
+	resource, ok := LookupResource(name)
+	return resource, priority, ok
+}
+
 // Allow return of the current request is allowed to proceed or should be avoided
 func (ruleSet *RuleSet) Allow(req *Request) bool {
-	return ruleSet.white.Match(req) || !ruleSet.black.Match(req)
+	return ruleSet.Check(req).Allowed
 }
 
 // CreateRuleSet Creates a fresh new empty RuleSet
 func CreateRuleSet() *RuleSet {
 	return &RuleSet{
-		white: &matcher{
-			addressPartMatcher: &pathMatcher{
-				next: map[rune]*pathMatcher{},
-			},
-			domainNameMatcher: &pathMatcher{
-				next: map[rune]*pathMatcher{},
-			},
-			exactAddressMatcher: &pathMatcher{
-				next: map[rune]*pathMatcher{},
-			},
-		},
-		black: &matcher{
-			addressPartMatcher: &pathMatcher{
-				next: map[rune]*pathMatcher{},
-			},
-			domainNameMatcher: &pathMatcher{
-				next: map[rune]*pathMatcher{},
-			},
-			exactAddressMatcher: &pathMatcher{
-				next: map[rune]*pathMatcher{},
-			},
+		white:            newMatcher(),
+		black:            newMatcher(),
+		genericBlack:     newMatcher(),
+		importantWhite:   newMatcher(),
+		importantBlack:   newMatcher(),
+		redirectBlack:    newMatcher(),
+		redirectWhite:    newMatcher(),
+		removeParamBlack: newMatcher(),
+		removeParamWhite: newMatcher(),
+		cspBlack:         newMatcher(),
+		cspWhite:         newMatcher(),
+		headerBlack:      newMatcher(),
+		headerWhite:      newMatcher(),
+		pageWhite: map[string]*matcher{
+			"document":     newMatcher(),
+			"elemhide":     newMatcher(),
+			"generichide":  newMatcher(),
+			"genericblock": newMatcher(),
 		},
+		badFilters: map[string]struct{}{},
 	}
 }
 
@@ -4,6 +4,8 @@ import (
 	"errors"
 	"fmt"
 	"net/url"
+	"regexp/syntax"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -28,15 +30,11 @@ func newRuleSetFromList(rulesStr []string) (*RuleSet, error) {
 		rule, err := ParseRule(ruleStr)
 		switch {
 		case err == nil:
-			if !rule.isException {
-				ruleSet.black.Add(rule)
-			}
-			if rule.isException {
-				ruleSet.white.Add(rule)
-			}
+			ruleSet.AddRule(rule)
+		case errors.Is(err, ErrUnsupportedRule):
+			return nil, err
 		case errors.Is(err, ErrSkipComment),
 			errors.Is(err, ErrSkipHTML),
-			errors.Is(err, ErrUnsupportedRule),
 			errors.Is(err, ErrEmptyLine):
 			return nil, fmt.Errorf("%w: %s", err, ruleStr)
 		default:
@@ -62,7 +60,40 @@ func TestParsingHTMLRule(t *testing.T) {
 func TestParsingBadOptionRule(t *testing.T) {
 	ruleText := "||domain.net^$badoption"
 	_, err := ParseRule(ruleText)
-	assert.EqualError(t, err, "Unsupported option rules are skipped")
+	assert.EqualError(t, err, `Unsupported option rules are skipped: option "badoption" in "||domain.net^$badoption"`)
+	assert.True(t, errors.Is(err, ErrUnsupportedRule))
+
+	var parseErr *ParseError
+	assert.True(t, errors.As(err, &parseErr))
+	assert.Equal(t, 0, parseErr.Line)
+	assert.Equal(t, ruleText, parseErr.Rule)
+	assert.Equal(t, "badoption", parseErr.Option)
+}
+
+func TestParsingEmptyDomainOption(t *testing.T) {
+	for _, ruleText := range []string{"||ads.net^$domain=", "||ads.net^$domain=a.com||b.com", "||ads.net^$domain=a.com|~"} {
+		_, err := ParseRule(ruleText)
+		assert.True(t, errors.Is(err, ErrUnsupportedRule), ruleText)
+		var parseErr *ParseError
+		assert.True(t, errors.As(err, &parseErr), ruleText)
+		assert.Equal(t, "domain=", parseErr.Option)
+	}
+}
+
+func TestParsingBadRegexRule(t *testing.T) {
+	ruleText := "@@/ads(/$script"
+	_, err := ParseRule(ruleText)
+	var parseErr *ParseError
+	assert.True(t, errors.As(err, &parseErr))
+	assert.Equal(t, ruleText, parseErr.Rule)
+	assert.Equal(t, "", parseErr.Option)
+	assert.Contains(t, err.Error(), "Cannot compile regex")
+	assert.True(t, errors.Is(err, ErrBadRegex))
+
+	// The error of the regex compiler is kept
+	var syntaxErr *syntax.Error
+	assert.True(t, errors.As(err, &syntaxErr))
+	assert.Equal(t, syntax.ErrMissingParen, syntaxErr.Code)
 }
 
 func TestCommentRule(t *testing.T) {
@@ -98,7 +129,7 @@ func TestBadOptionRule(t *testing.T) {
 	ruleText := "||domain.net^$badoption"
 	rules := []string{ruleText}
 	_, err := newRuleSetFromList(rules)
-	assert.EqualError(t, err, "Unsupported option rules are skipped: ||domain.net^$badoption")
+	assert.EqualError(t, err, `Unsupported option rules are skipped: option "badoption" in "||domain.net^$badoption"`)
 }
 
 func TestExceptionRule(t *testing.T) {
@@ -436,5 +467,840 @@ func TestRegex(t *testing.T) {
 func TestRegexLooksLikePath(t *testing.T) {
 	ruleText := "/hi/"
 	rule, _ := ParseRule(ruleText)
-	assert.Equal(t, rule.ruleType, regexRule)
+	assert.Equal(t, rule.ruleType, RegexRule)
+}
+
+func TestRegexRuleOptions(t *testing.T) {
+	rules := []string{`/banner\d+/$script`, `/ads\d+/$domain=example.com`}
+	ruleSet, err := newRuleSetFromList(rules)
+	assert.NoError(t, err)
+
+	assert.False(t, ruleSet.Allow(reqFromURL("http://cdn.com/banner1.js")))
+	assert.True(t, ruleSet.Allow(reqFromURL("http://cdn.com/banner1.gif")))
+
+	assert.False(t, ruleSet.Allow(reqFromURL("http://example.com/ads1.png")))
+	assert.True(t, ruleSet.Allow(reqFromURL("http://other.com/ads1.png")))
+}
+
+func TestRuleWithThirdPartyOption(t *testing.T) {
+	rules := []string{"||ads.example.com^$third-party"}
+	ruleSet, err := newRuleSetFromList(rules)
+	assert.NoError(t, err)
+
+	req := reqFromURL("http://ads.example.com/banner.gif")
+	// No page context means first-party
+	assert.True(t, ruleSet.Allow(req))
+	req.Referer = "http://www.example.com/page.html"
+	assert.True(t, ruleSet.Allow(req))
+	req.Referer = "http://www.other.com/page.html"
+	assert.False(t, ruleSet.Allow(req))
+
+	// Origin is used when there is no Referer
+	req = reqFromURL("http://ads.example.com/banner.gif")
+	req.Origin = "https://other.com"
+	assert.False(t, ruleSet.Allow(req))
+	req.Origin = "https://example.com"
+	assert.True(t, ruleSet.Allow(req))
+
+	// Registrable domain takes public suffixes into account
+	req = reqFromURL("http://ads.example.co.uk/banner.gif")
+	req.Referer = "http://other.co.uk/"
+	rules = []string{"||ads.example.co.uk^$third-party"}
+	ruleSet, err = newRuleSetFromList(rules)
+	assert.NoError(t, err)
+	assert.False(t, ruleSet.Allow(req))
+	req.Referer = "http://www.example.co.uk/"
+	assert.True(t, ruleSet.Allow(req))
+}
+
+func TestRuleWithNegateThirdPartyOption(t *testing.T) {
+	rules := []string{"||ads.example.com^$~third-party"}
+	ruleSet, err := newRuleSetFromList(rules)
+	assert.NoError(t, err)
+
+	req := reqFromURL("http://ads.example.com/banner.gif")
+	assert.False(t, ruleSet.Allow(req))
+	req.Referer = "http://www.example.com/page.html"
+	assert.False(t, ruleSet.Allow(req))
+	req.Referer = "http://www.other.com/page.html"
+	assert.True(t, ruleSet.Allow(req))
+}
+
+func TestRuleWithThirdPartyAndScriptOptions(t *testing.T) {
+	rules := []string{"||ads.example.com^$script,third-party"}
+	ruleSet, err := newRuleSetFromList(rules)
+	assert.NoError(t, err)
+
+	req := reqFromURL("http://ads.example.com/file.js")
+	req.Referer = "http://www.other.com/page.html"
+	assert.False(t, ruleSet.Allow(req))
+	req.Referer = "http://www.example.com/page.html"
+	assert.True(t, ruleSet.Allow(req))
+
+	req = reqFromURL("http://ads.example.com/file.css")
+	req.Referer = "http://www.other.com/page.html"
+	assert.True(t, ruleSet.Allow(req))
+}
+
+func TestRuleWithXHROption(t *testing.T) {
+	rules := []string{"||ads.example.com^$xmlhttprequest"}
+	ruleSet, err := newRuleSetFromList(rules)
+	assert.NoError(t, err)
+
+	req := reqFromURL("http://ads.example.com/data")
+	assert.True(t, ruleSet.Allow(req))
+	req.IsXHR = true
+	assert.False(t, ruleSet.Allow(req))
+
+	rules = []string{"||ads.example.com^$~xmlhttprequest"}
+	ruleSet, err = newRuleSetFromList(rules)
+	assert.NoError(t, err)
+
+	req = reqFromURL("http://ads.example.com/data")
+	assert.False(t, ruleSet.Allow(req))
+	req.IsXHR = true
+	assert.True(t, ruleSet.Allow(req))
+}
+
+func TestRuleWithScriptAndXHROptions(t *testing.T) {
+	rules := []string{"||ads.example.com^$script,xmlhttprequest"}
+	ruleSet, err := newRuleSetFromList(rules)
+	assert.NoError(t, err)
+
+	assert.False(t, ruleSet.Allow(reqFromURL("http://ads.example.com/file.js")))
+	assert.True(t, ruleSet.Allow(reqFromURL("http://ads.example.com/file.css")))
+	assert.True(t, ruleSet.Allow(reqFromURL("http://ads.example.com/data")))
+	req := reqFromURL("http://ads.example.com/data")
+	req.IsXHR = true
+	assert.False(t, ruleSet.Allow(req))
+
+	rules = []string{"||ads.example.com^$~script,~xmlhttprequest"}
+	ruleSet, err = newRuleSetFromList(rules)
+	assert.NoError(t, err)
+
+	assert.True(t, ruleSet.Allow(reqFromURL("http://ads.example.com/file.js")))
+	assert.False(t, ruleSet.Allow(reqFromURL("http://ads.example.com/file.css")))
+	assert.False(t, ruleSet.Allow(reqFromURL("http://ads.example.com/data")))
+	req = reqFromURL("http://ads.example.com/data")
+	req.IsXHR = true
+	assert.True(t, ruleSet.Allow(req))
+}
+
+func TestRuleWithExplicitResourceType(t *testing.T) {
+	rules := []string{"||ads.example.com^$image", "||tracker.example.com^$~script"}
+	ruleSet, err := newRuleSetFromList(rules)
+	assert.NoError(t, err)
+
+	// No extension, the type must be given
+	req := reqFromURL("http://ads.example.com/pixel?id=1")
+	assert.True(t, ruleSet.Allow(req))
+	req.ResourceType = ResourceImage
+	assert.False(t, ruleSet.Allow(req))
+	req.ResourceType = ResourceScript
+	assert.True(t, ruleSet.Allow(req))
+
+	// Explicit type takes precedence over the extension
+	req = reqFromURL("http://ads.example.com/banner.js")
+	req.ResourceType = ResourceImage
+	assert.False(t, ruleSet.Allow(req))
+	req = reqFromURL("http://ads.example.com/banner.gif")
+	req.ResourceType = ResourceScript
+	assert.True(t, ruleSet.Allow(req))
+
+	req = reqFromURL("http://tracker.example.com/loader")
+	assert.False(t, ruleSet.Allow(req))
+	req.ResourceType = ResourceScript
+	assert.True(t, ruleSet.Allow(req))
+	req = reqFromURL("http://tracker.example.com/loader.js")
+	req.ResourceType = ResourceOther
+	assert.False(t, ruleSet.Allow(req))
+}
+
+func TestRuleWithXHRResourceType(t *testing.T) {
+	rules := []string{"||ads.example.com^$xmlhttprequest"}
+	ruleSet, err := newRuleSetFromList(rules)
+	assert.NoError(t, err)
+
+	req := reqFromURL("http://ads.example.com/data")
+	req.ResourceType = ResourceXHR
+	assert.False(t, ruleSet.Allow(req))
+	// Explicit type overrides IsXHR
+	req.ResourceType = ResourceImage
+	req.IsXHR = true
+	assert.True(t, ruleSet.Allow(req))
+}
+
+func TestParsingResourceTypeOptions(t *testing.T) {
+	for _, option := range []string{"subdocument", "media", "object", "ping", "websocket", "other", "document", "popup"} {
+		rule, err := ParseRule("||ads.example.com^$" + option)
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]bool{option: true}, rule.options)
+
+		rule, err = ParseRule("||ads.example.com^$~" + option)
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]bool{option: false}, rule.options)
+	}
+
+	rule, err := ParseRule("||ads.example.com^$xhr,~css,frame")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]bool{"xmlhttprequest": true, "stylesheet": false, "subdocument": true}, rule.options)
+}
+
+func TestRuleWithResourceTypeOptions(t *testing.T) {
+	rules := []string{"||ads.example.com^$subdocument,websocket", "||cdn.example.com^$~media,~object"}
+	ruleSet, err := newRuleSetFromList(rules)
+	assert.NoError(t, err)
+
+	req := reqFromURL("http://ads.example.com/frame")
+	assert.True(t, ruleSet.Allow(req))
+	req.ResourceType = ResourceSubdocument
+	assert.False(t, ruleSet.Allow(req))
+	req.ResourceType = ResourceWebSocket
+	assert.False(t, ruleSet.Allow(req))
+	req.ResourceType = ResourcePing
+	assert.True(t, ruleSet.Allow(req))
+
+	req = reqFromURL("http://cdn.example.com/stream")
+	assert.False(t, ruleSet.Allow(req))
+	req.ResourceType = ResourceMedia
+	assert.True(t, ruleSet.Allow(req))
+	req.ResourceType = ResourceObject
+	assert.True(t, ruleSet.Allow(req))
+	req.ResourceType = ResourceOther
+	assert.False(t, ruleSet.Allow(req))
+	assert.True(t, ruleSet.Allow(reqFromURL("http://cdn.example.com/video.mp4")))
+}
+
+func TestRuleWithPopupOption(t *testing.T) {
+	rules := []string{"||ads.example.com^", "||popups.example.com^$popup"}
+	ruleSet, err := newRuleSetFromList(rules)
+	assert.NoError(t, err)
+
+	req := reqFromURL("http://ads.example.com/")
+	assert.False(t, ruleSet.Allow(req))
+	// Popups are only blocked by explicit rules
+	req.ResourceType = ResourcePopup
+	assert.True(t, ruleSet.Allow(req))
+
+	req = reqFromURL("http://popups.example.com/")
+	assert.True(t, ruleSet.Allow(req))
+	req.ResourceType = ResourcePopup
+	assert.False(t, ruleSet.Allow(req))
+}
+
+func TestRuleWithDomainOptionMatchesPage(t *testing.T) {
+	rules := []string{"||ads.com^$domain=example.com|~bar.example.com"}
+	ruleSet, err := newRuleSetFromList(rules)
+	assert.NoError(t, err)
+
+	req := reqFromURL("http://ads.com/banner.gif")
+	req.Referer = "http://www.example.com/page.html"
+	assert.False(t, ruleSet.Allow(req))
+	req.Referer = "http://bar.example.com/page.html"
+	assert.True(t, ruleSet.Allow(req))
+	req.Referer = "http://other.com/page.html"
+	assert.True(t, ruleSet.Allow(req))
+
+	req = reqFromURL("http://ads.com/banner.gif")
+	req.Origin = "http://example.com"
+	assert.False(t, ruleSet.Allow(req))
+
+	// The request host doesn't matter when there is a page
+	rules = []string{"/banner/*$domain=example.com"}
+	ruleSet, err = newRuleSetFromList(rules)
+	assert.NoError(t, err)
+	req = reqFromURL("http://example.com/banner/img.gif")
+	req.Referer = "http://other.com/"
+	assert.True(t, ruleSet.Allow(req))
+	req = reqFromURL("http://cdn.net/banner/img.gif")
+	req.Referer = "http://example.com/"
+	assert.False(t, ruleSet.Allow(req))
+}
+
+func TestRuleWithDomainOptionWithoutPage(t *testing.T) {
+	rules := []string{"/banner/*$domain=example.com"}
+	ruleSet, err := newRuleSetFromList(rules)
+	assert.NoError(t, err)
+
+	// Without page context the request is its own page
+	assert.False(t, ruleSet.Allow(reqFromURL("http://example.com/banner/img.gif")))
+	assert.True(t, ruleSet.Allow(reqFromURL("http://other.com/banner/img.gif")))
+
+	// Documents are their own page, the Referer is the previous one
+	req := reqFromURL("http://example.com/banner/page.html")
+	req.ResourceType = ResourceDocument
+	req.Referer = "http://other.com/"
+	assert.False(t, ruleSet.Allow(req))
+}
+
+func TestDomainNameRuleMatchesLabels(t *testing.T) {
+	rules := []string{"||example.com^"}
+	ruleSet, err := newRuleSetFromList(rules)
+	assert.NoError(t, err)
+
+	assert.False(t, ruleSet.Allow(reqFromURL("http://example.com/")))
+	assert.False(t, ruleSet.Allow(reqFromURL("http://ads.example.com/")))
+	assert.True(t, ruleSet.Allow(reqFromURL("http://notexample.com/")))
+	assert.True(t, ruleSet.Allow(reqFromURL("http://example.com.ua/")))
+
+	// Fully qualified hostnames
+	assert.False(t, ruleSet.Allow(reqFromURL("http://example.com./")))
+	assert.False(t, ruleSet.Allow(reqFromURL("http://ads.example.com.:8080/")))
+}
+
+func TestDomainOptionMatchesLabels(t *testing.T) {
+	rules := []string{"/banner/*$domain=ample.com"}
+	ruleSet, err := newRuleSetFromList(rules)
+	assert.NoError(t, err)
+
+	req := reqFromURL("http://cdn.net/banner/img.gif")
+	req.Referer = "http://example.com/"
+	assert.True(t, ruleSet.Allow(req))
+	req.Referer = "http://ample.com/"
+	assert.False(t, ruleSet.Allow(req))
+	req.Referer = "http://www.ample.com./"
+	assert.False(t, ruleSet.Allow(req))
+	req.Referer = "http://WWW.AMPLE.COM/"
+	assert.False(t, ruleSet.Allow(req))
+}
+
+func TestIDNDomains(t *testing.T) {
+	rules := []string{"||пример.рф^", "/banner/*$domain=bücher.de"}
+	ruleSet, err := newRuleSetFromList(rules)
+	assert.NoError(t, err)
+
+	assert.False(t, ruleSet.Allow(reqFromURL("http://пример.рф/")))
+	assert.False(t, ruleSet.Allow(reqFromURL("http://ads.пример.рф./")))
+	assert.False(t, ruleSet.Allow(reqFromURL("http://xn--e1afmkfd.xn--p1ai/")))
+	assert.True(t, ruleSet.Allow(reqFromURL("http://другой.рф/")))
+
+	req := reqFromURL("http://cdn.net/banner/img.gif")
+	req.Referer = "http://www.bücher.de/"
+	assert.False(t, ruleSet.Allow(req))
+	req.Referer = "http://www.xn--bcher-kva.de/"
+	assert.False(t, ruleSet.Allow(req))
+	req.Referer = "http://bucher.de/"
+	assert.True(t, ruleSet.Allow(req))
+}
+
+func TestRuleWithMixedDomainOption(t *testing.T) {
+	rules := []string{"/banner/*$domain=a.com|~b.a.com|c.b.a.com"}
+	ruleSet, err := newRuleSetFromList(rules)
+	assert.NoError(t, err)
+
+	req := reqFromURL("http://cdn.net/banner/img.gif")
+	for referer, allowed := range map[string]bool{
+		"http://a.com/":         false,
+		"http://www.a.com/":     false,
+		"http://b.a.com/":       true,
+		"http://www.b.a.com/":   true,
+		"http://c.b.a.com/":     false,
+		"http://www.c.b.a.com/": false,
+		"http://other.com/":     true,
+	} {
+		req.Referer = referer
+		assert.Equal(t, allowed, ruleSet.Allow(req), referer)
+	}
+}
+
+func TestRuleWithExcludedDomainsOnly(t *testing.T) {
+	rules := []string{"/banner/*$domain=~a.com|~b.com"}
+	ruleSet, err := newRuleSetFromList(rules)
+	assert.NoError(t, err)
+
+	req := reqFromURL("http://cdn.net/banner/img.gif")
+	for referer, allowed := range map[string]bool{
+		"http://a.com/":     true,
+		"http://www.b.com/": true,
+		"http://c.com/":     false,
+		"http://aa.com/":    false,
+	} {
+		req.Referer = referer
+		assert.Equal(t, allowed, ruleSet.Allow(req), referer)
+	}
+}
+
+func TestExceptionWithMixedDomainOption(t *testing.T) {
+	rules := []string{"/banner/*", "@@/banner/*$domain=~a.com|b.a.com"}
+	ruleSet, err := newRuleSetFromList(rules)
+	assert.NoError(t, err)
+
+	req := reqFromURL("http://cdn.net/banner/img.gif")
+	for referer, allowed := range map[string]bool{
+		"http://a.com/":       false,
+		"http://b.a.com/":     true,
+		"http://www.b.a.com/": true,
+		"http://other.com/":   false,
+	} {
+		req.Referer = referer
+		assert.Equal(t, allowed, ruleSet.Allow(req), referer)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	rules := []string{"||ads.example.com^", "@@||ads.example.com^$script", "/banner/*"}
+	ruleSet, err := newRuleSetFromList(rules)
+	assert.NoError(t, err)
+
+	result := ruleSet.Check(reqFromURL("http://example.com/"))
+	assert.True(t, result.Allowed)
+	assert.Nil(t, result.Rule)
+	assert.Nil(t, result.Exception)
+
+	result = ruleSet.Check(reqFromURL("http://ads.example.com/banner.gif"))
+	assert.False(t, result.Allowed)
+	assert.Equal(t, "||ads.example.com^", result.Rule.Text())
+	assert.Nil(t, result.Exception)
+
+	result = ruleSet.Check(reqFromURL("http://ads.example.com/file.js"))
+	assert.True(t, result.Allowed)
+	assert.Equal(t, "||ads.example.com^", result.Rule.Text())
+	assert.Equal(t, "@@||ads.example.com^$script", result.Exception.Text())
+
+	result = ruleSet.Check(reqFromURL("http://example.com/banner/img.gif"))
+	assert.False(t, result.Allowed)
+	assert.Equal(t, "/banner/*", result.Rule.Text())
+}
+
+func TestRuleAccessors(t *testing.T) {
+	rule, err := ParseRule("@@||ads.example.com^$script,~third-party,domain=b.com|~a.b.com|a.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "||ads.example.com^", rule.Pattern())
+	assert.Equal(t, DomainName, rule.Type())
+	assert.Equal(t, "domain name", rule.Type().String())
+	assert.True(t, rule.IsException())
+	assert.Equal(t, map[string]bool{"script": true, "third-party": false}, rule.Options())
+	assert.Equal(t, []string{"a.com", "b.com"}, rule.IncludedDomains())
+	assert.Equal(t, []string{"a.b.com"}, rule.ExcludedDomains())
+
+	// Changing the returned values doesn't change the rule
+	rule.Options()["script"] = false
+	assert.Equal(t, map[string]bool{"script": true, "third-party": false}, rule.Options())
+
+	rule, err = ParseRule("/banner/*/img")
+	assert.NoError(t, err)
+	assert.Equal(t, AddressPart, rule.Type())
+	assert.False(t, rule.IsException())
+	assert.Empty(t, rule.Options())
+	assert.Empty(t, rule.IncludedDomains())
+	assert.Empty(t, rule.ExcludedDomains())
+
+	rule, err = ParseRule("|http://example.com/|")
+	assert.NoError(t, err)
+	assert.Equal(t, ExactAddress, rule.Type())
+	rule, err = ParseRule("/banner\\d+/")
+	assert.NoError(t, err)
+	assert.Equal(t, RegexRule, rule.Type())
+	assert.Equal(t, "/banner\\d+/", rule.Pattern())
+}
+
+func TestRuleString(t *testing.T) {
+	for ruleText, canonical := range map[string]string{
+		"||ads.example.com^":                   "||ads.example.com^",
+		"  /banner/*/img^  ":                   "/banner/*/img^",
+		"@@||ads.example.com^$script":          "@@||ads.example.com^$script",
+		"||ads.example.com^$xhr,~css,frame":    "||ads.example.com^$~stylesheet,subdocument,xmlhttprequest",
+		"/ads/*$domain=B.com|~a.b.com|a.com":   "/ads/*$domain=~a.b.com|a.com|b.com",
+		"/ads/*$~third-party,domain=x.com,css": "/ads/*$domain=x.com,stylesheet,~third-party",
+		"||пример.рф^$match-case":              "||xn--e1afmkfd.xn--p1ai^$match-case",
+		"|http://example.com/|":                "|http://example.com/|",
+		"/banner\\d+/$image":                   "/banner\\d+/$image",
+	} {
+		rule, err := ParseRule(ruleText)
+		assert.NoError(t, err)
+		assert.Equal(t, canonical, rule.String(), ruleText)
+
+		// Parsing the canonical text gives the same rule
+		reparsed, err := ParseRule(rule.String())
+		assert.NoError(t, err)
+		assert.Equal(t, rule.String(), reparsed.String())
+		assert.Equal(t, rule.Pattern(), reparsed.Pattern())
+		assert.Equal(t, rule.Type(), reparsed.Type())
+		assert.Equal(t, rule.IsException(), reparsed.IsException())
+		assert.Equal(t, rule.Options(), reparsed.Options())
+		assert.Equal(t, rule.IncludedDomains(), reparsed.IncludedDomains())
+		assert.Equal(t, rule.ExcludedDomains(), reparsed.ExcludedDomains())
+		assert.Equal(t, rule.regex.String(), reparsed.regex.String())
+	}
+}
+
+func TestImportantRule(t *testing.T) {
+	rules := []string{
+		"||ads.example.com^$important",
+		"@@||ads.example.com^",
+		"||tracker.com^",
+		"@@||tracker.com^",
+	}
+	ruleSet, err := newRuleSetFromList(rules)
+	assert.NoError(t, err)
+
+	// Important rules win over exceptions
+	result := ruleSet.Check(reqFromURL("http://ads.example.com/banner.gif"))
+	assert.False(t, result.Allowed)
+	assert.Equal(t, "||ads.example.com^$important", result.Rule.Text())
+	assert.Nil(t, result.Exception)
+
+	// Others are still overridden
+	assert.True(t, ruleSet.Allow(reqFromURL("http://tracker.com/")))
+}
+
+func TestImportantException(t *testing.T) {
+	rules := []string{
+		"||ads.example.com^$important",
+		"@@||ads.example.com^$script,important",
+		"||tracker.com^",
+		"@@||tracker.com^$important",
+	}
+	ruleSet, err := newRuleSetFromList(rules)
+	assert.NoError(t, err)
+
+	assert.False(t, ruleSet.Allow(reqFromURL("http://ads.example.com/banner.gif")))
+	result := ruleSet.Check(reqFromURL("http://ads.example.com/file.js"))
+	assert.True(t, result.Allowed)
+	assert.Equal(t, "||ads.example.com^$important", result.Rule.Text())
+	assert.Equal(t, "@@||ads.example.com^$script,important", result.Exception.Text())
+
+	// Important exceptions also override regular rules
+	assert.True(t, ruleSet.Allow(reqFromURL("http://tracker.com/")))
+}
+
+func TestParsingImportantOption(t *testing.T) {
+	rule, err := ParseRule("||ads.example.com^$important,script")
+	assert.NoError(t, err)
+	assert.Equal(t, "||ads.example.com^$important,script", rule.String())
+
+	_, err = ParseRule("||ads.example.com^$~important")
+	assert.True(t, errors.Is(err, ErrUnsupportedRule))
+}
+
+func TestBadFilterRule(t *testing.T) {
+	rules := []string{
+		"||ads.example.com^$script",
+		"||ads.example.com^",
+		"||ads.example.com^$badfilter",
+		"@@||tracker.com^$~script,domain=tracker.com|b.com",
+		"||tracker.com^",
+	}
+	ruleSet, err := newRuleSetFromList(rules)
+	assert.NoError(t, err)
+
+	// Only the identical rule is disabled
+	assert.True(t, ruleSet.Allow(reqFromURL("http://ads.example.com/banner.gif")))
+	assert.False(t, ruleSet.Allow(reqFromURL("http://ads.example.com/file.js")))
+
+	// Options and domains in any order, rules added before or after
+	assert.True(t, ruleSet.Allow(reqFromURL("http://tracker.com/")))
+	ruleSet.AddRule(mustParseRule(t, "@@||tracker.com^$domain=b.com|tracker.com,badfilter,~script"))
+	assert.False(t, ruleSet.Allow(reqFromURL("http://tracker.com/")))
+	ruleSet.AddRule(mustParseRule(t, "@@||tracker.com^$~script,domain=tracker.com|b.com"))
+	assert.False(t, ruleSet.Allow(reqFromURL("http://tracker.com/")))
+}
+
+func TestBadFilterRuleCase(t *testing.T) {
+	rules := []string{
+		"||Ads.example.com^",
+		"||ads.example.com^$badfilter",
+		"/Banner.gif$match-case",
+		"/banner.gif$match-case,badfilter",
+	}
+	ruleSet, err := newRuleSetFromList(rules)
+	assert.NoError(t, err)
+
+	assert.True(t, ruleSet.Allow(reqFromURL("http://ads.example.com/")))
+	ruleSet.AddRule(mustParseRule(t, "||ADS.example.com^"))
+	assert.True(t, ruleSet.Allow(reqFromURL("http://ads.example.com/")))
+	assert.False(t, ruleSet.Allow(reqFromURL("http://cdn.com/Banner.gif")))
+}
+
+func TestBadFilterWildcardRules(t *testing.T) {
+	rules := []string{
+		"/banner/*/img^",
+		"/banner/*/img^$badfilter",
+		"*$script,domain=example.*",
+		"/ads\\d+/",
+	}
+	ruleSet, err := newRuleSetFromList(rules)
+	assert.NoError(t, err)
+
+	assert.True(t, ruleSet.Allow(reqFromURL("http://example.com/banner/foo/img")))
+	assert.False(t, ruleSet.Allow(reqFromURL("http://example.com/file.js")))
+	assert.False(t, ruleSet.Allow(reqFromURL("http://www.example.co.uk/file.js")))
+	assert.True(t, ruleSet.Allow(reqFromURL("http://notexample.com/file.js")))
+	assert.False(t, ruleSet.Allow(reqFromURL("http://other.com/ads12")))
+
+	ruleSet.AddRule(mustParseRule(t, "*$domain=example.*,script,badfilter"))
+	ruleSet.AddRule(mustParseRule(t, "/ads\\d+/$badfilter"))
+	assert.True(t, ruleSet.Allow(reqFromURL("http://example.com/file.js")))
+	assert.True(t, ruleSet.Allow(reqFromURL("http://www.example.co.uk/file.js")))
+	assert.True(t, ruleSet.Allow(reqFromURL("http://other.com/ads12")))
+}
+
+func TestBadFilterAcrossLists(t *testing.T) {
+	ruleSet, _, err := LoadRuleSet(strings.NewReader("||ads.example.com^\n||ads.example.com^$important"))
+	assert.NoError(t, err)
+	_, err = ruleSet.AddList(strings.NewReader("||ads.example.com^$important,badfilter"))
+	assert.NoError(t, err)
+
+	result := ruleSet.Check(reqFromURL("http://ads.example.com/"))
+	assert.False(t, result.Allowed)
+	assert.Equal(t, "||ads.example.com^", result.Rule.Text())
+}
+
+func mustParseRule(t *testing.T, ruleText string) *RuleAdBlock {
+	rule, err := ParseRule(ruleText)
+	assert.NoError(t, err)
+	return rule
+}
+
+func TestDocumentException(t *testing.T) {
+	rules := []string{
+		"||ads.example.com^",
+		"||tracker.com^$important",
+		"@@||partner.com^$document",
+	}
+	ruleSet, err := newRuleSetFromList(rules)
+	assert.NoError(t, err)
+
+	req := reqFromURL("http://ads.example.com/banner.gif")
+	req.Referer = "http://www.partner.com/article.html"
+	result := ruleSet.Check(req)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, "||ads.example.com^", result.Rule.Text())
+	assert.Equal(t, "@@||partner.com^$document", result.Exception.Text())
+
+	req.Referer = "http://other.com/article.html"
+	assert.False(t, ruleSet.Allow(req))
+
+	// Important rules are still applied
+	req = reqFromURL("http://tracker.com/pixel")
+	req.Referer = "http://www.partner.com/article.html"
+	assert.False(t, ruleSet.Allow(req))
+
+	// The exception doesn't apply to requests to the domain from other pages
+	rules = []string{"||partner.com^", "@@||partner.com^$document"}
+	ruleSet, err = newRuleSetFromList(rules)
+	assert.NoError(t, err)
+	req = reqFromURL("http://partner.com/widget.js")
+	req.Referer = "http://other.com/"
+	assert.False(t, ruleSet.Allow(req))
+	req.ResourceType = ResourceDocument
+	assert.True(t, ruleSet.Allow(req))
+}
+
+func TestGenericBlockException(t *testing.T) {
+	rules := []string{
+		"/banner/*",
+		"/ads/*$domain=example.com",
+		"/track/*$domain=~other.com",
+		"@@||example.com^$genericblock",
+	}
+	ruleSet, err := newRuleSetFromList(rules)
+	assert.NoError(t, err)
+
+	req := reqFromURL("http://cdn.net/banner/img.gif")
+	req.Referer = "http://example.com/"
+	assert.True(t, ruleSet.Allow(req))
+	req.Referer = "http://example.net/"
+	assert.False(t, ruleSet.Allow(req))
+
+	// Rules restricted to some domains are still applied
+	req = reqFromURL("http://cdn.net/ads/img.gif")
+	req.Referer = "http://example.com/"
+	assert.False(t, ruleSet.Allow(req))
+
+	// Only excluding domains is still generic
+	req = reqFromURL("http://cdn.net/track/img.gif")
+	req.Referer = "http://example.com/"
+	assert.True(t, ruleSet.Allow(req))
+}
+
+func TestPageExceptions(t *testing.T) {
+	rules := []string{
+		"@@||partner.com^$document",
+		"@@||news.com^$elemhide",
+		"@@||shop.com^$generichide,genericblock",
+		"@@||blog.com^$ghide",
+	}
+	ruleSet, err := newRuleSetFromList(rules)
+	assert.NoError(t, err)
+
+	pageURL, _ := url.Parse("http://www.partner.com/")
+	exceptions := ruleSet.PageExceptions(pageURL)
+	assert.Equal(t, PageExceptions{Document: true}, exceptions)
+	assert.True(t, exceptions.CosmeticDisabled())
+
+	pageURL, _ = url.Parse("http://news.com/article")
+	exceptions = ruleSet.PageExceptions(pageURL)
+	assert.Equal(t, PageExceptions{ElemHide: true}, exceptions)
+	assert.True(t, exceptions.CosmeticDisabled())
+
+	pageURL, _ = url.Parse("http://shop.com/")
+	exceptions = ruleSet.PageExceptions(pageURL)
+	assert.Equal(t, PageExceptions{GenericHide: true, GenericBlock: true}, exceptions)
+	assert.False(t, exceptions.CosmeticDisabled())
+
+	pageURL, _ = url.Parse("http://blog.com/")
+	assert.Equal(t, PageExceptions{GenericHide: true}, ruleSet.PageExceptions(pageURL))
+
+	pageURL, _ = url.Parse("http://other.com/")
+	assert.Equal(t, PageExceptions{}, ruleSet.PageExceptions(pageURL))
+}
+
+func TestParsingPageOptions(t *testing.T) {
+	rule, err := ParseRule("@@||partner.com^$document,elemhide")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]bool{"document": true, "elemhide": true}, rule.Options())
+
+	_, err = ParseRule("||partner.com^$elemhide")
+	assert.True(t, errors.Is(err, ErrUnsupportedRule))
+	_, err = ParseRule("@@||partner.com^$~genericblock")
+	assert.True(t, errors.Is(err, ErrUnsupportedRule))
+}
+
+func TestParsingRedirect(t *testing.T) {
+	rule, err := ParseRule("||ads.com^$script,redirect=noopjs:10")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]bool{"script": true, "redirect": true}, rule.Options())
+	value, ok := rule.OptionValue("redirect")
+	assert.True(t, ok)
+	assert.Equal(t, "noopjs:10", value)
+	assert.Equal(t, "||ads.com^$redirect=noopjs:10,script", rule.String())
+
+	rule, err = ParseRule("||ads.com^$script,redirect=abp-resource:blank-js")
+	assert.NoError(t, err)
+	resource, priority, ok := parseRedirect(rule.redirectValue())
+	assert.True(t, ok)
+	assert.Equal(t, "noop.js", resource.Name)
+	assert.Equal(t, 0, priority)
+
+	rule, err = ParseRule("||ads.com^$image,redirect-rule=abp-resource:1x1-transparent-gif:5")
+	assert.NoError(t, err)
+	resource, priority, ok = parseRedirect(rule.redirectValue())
+	assert.True(t, ok)
+	assert.Equal(t, "1x1.gif", resource.Name)
+	assert.Equal(t, 5, priority)
+
+	rule, err = ParseRule("@@||ads.com^$redirect-rule")
+	assert.NoError(t, err)
+	_, ok = rule.OptionValue("redirect-rule")
+	assert.False(t, ok)
+
+	for _, ruleText := range []string{
+		"||ads.com^$redirect=unknown.js",
+		"||ads.com^$redirect=noopjs:high",
+		"||ads.com^$redirect=abp-resource:unknown",
+		"||ads.com^$redirect",
+		"||ads.com^$~redirect=noopjs",
+		"||ads.com^$image=1x1.gif",
+	} {
+		_, err = ParseRule(ruleText)
+		assert.True(t, errors.Is(err, ErrUnsupportedRule), ruleText)
+	}
+}
+
+func TestRedirect(t *testing.T) {
+	rules := []string{
+		"||ads.com^$script,redirect=noopjs",
+		"/pixel.gif$image,redirect-rule=1x1.gif",
+		"/track/pixel.gif",
+		"/banner/*$redirect-rule=2x2.png",
+		"/banner/*$redirect-rule=32x32.png:5",
+		"/banner/",
+		"@@/banner/keep/",
+		"@@/lib/$redirect",
+	}
+	ruleSet, err := newRuleSetFromList(rules)
+	assert.NoError(t, err)
+
+	// $redirect blocks and redirects
+	result := ruleSet.Check(reqFromURL("http://ads.com/ad.js"))
+	assert.False(t, result.Allowed)
+	assert.Equal(t, "noop.js", result.Redirect.Name)
+	assert.Equal(t, "application/javascript", result.Redirect.ContentType)
+	assert.Equal(t, "||ads.com^$redirect=noopjs,script", result.RedirectRule.String())
+
+	// Exceptions on $redirect only disable the redirect
+	result = ruleSet.Check(reqFromURL("http://ads.com/lib/ad.js"))
+	assert.False(t, result.Allowed)
+	assert.Nil(t, result.Redirect)
+
+	// $redirect-rule only redirects requests blocked by other rules
+	assert.True(t, ruleSet.Allow(reqFromURL("http://example.com/pixel.gif")))
+	result = ruleSet.Check(reqFromURL("http://tracker.com/track/pixel.gif"))
+	assert.False(t, result.Allowed)
+	assert.Equal(t, "/track/pixel.gif", result.Rule.String())
+	assert.Equal(t, "1x1.gif", result.Redirect.Name)
+
+	// The redirect with the highest priority wins
+	result = ruleSet.Check(reqFromURL("http://cdn.com/banner/top.png"))
+	assert.False(t, result.Allowed)
+	assert.Equal(t, "32x32.png", result.Redirect.Name)
+
+	// Allowed requests are not redirected
+	result = ruleSet.Check(reqFromURL("http://cdn.com/banner/keep/top.png"))
+	assert.True(t, result.Allowed)
+	assert.Nil(t, result.Redirect)
+	assert.Nil(t, result.RedirectRule)
+}
+
+func TestRedirectBadFilter(t *testing.T) {
+	rules := []string{
+		"||ads.com^$script,redirect=noopjs",
+		"||ads.com^$script,redirect=noopjs,badfilter",
+	}
+	ruleSet, err := newRuleSetFromList(rules)
+	assert.NoError(t, err)
+
+	result := ruleSet.Check(reqFromURL("http://ads.com/ad.js"))
+	assert.True(t, result.Allowed)
+	assert.Nil(t, result.Redirect)
+}
+
+func TestParsingMethod(t *testing.T) {
+	rule, err := ParseRule("||tracker.com^$method=POST|~get")
+	assert.NoError(t, err)
+	value, _ := rule.OptionValue("method")
+	assert.Equal(t, "post|~get", value)
+	assert.Equal(t, "||tracker.com^$method=post|~get", rule.String())
+
+	for _, ruleText := range []string{
+		"||tracker.com^$method",
+		"||tracker.com^$method=fetch",
+		"||tracker.com^$~method=post",
+	} {
+		_, err = ParseRule(ruleText)
+		assert.True(t, errors.Is(err, ErrUnsupportedRule), ruleText)
+	}
+}
+
+func TestMethod(t *testing.T) {
+	rules := []string{
+		"||beacon.com^$method=post|put",
+		"||tracker.com^$method=~get|~head",
+		"||ads.com^",
+		"@@||ads.com^$method=options",
+	}
+	ruleSet, err := newRuleSetFromList(rules)
+	assert.NoError(t, err)
+
+	req := reqFromURL("http://beacon.com/collect")
+	assert.True(t, ruleSet.Allow(req))
+	req.Method = "POST"
+	assert.False(t, ruleSet.Allow(req))
+	req.Method = "put"
+	assert.False(t, ruleSet.Allow(req))
+
+	req = reqFromURL("http://tracker.com/collect")
+	assert.True(t, ruleSet.Allow(req))
+	req.Method = "HEAD"
+	assert.True(t, ruleSet.Allow(req))
+	req.Method = "DELETE"
+	assert.False(t, ruleSet.Allow(req))
+
+	// Rules without $method match any method
+	req = reqFromURL("http://ads.com/banner.js")
+	req.Method = "POST"
+	assert.False(t, ruleSet.Allow(req))
+	req.Method = "OPTIONS"
+	assert.True(t, ruleSet.Allow(req))
 }